@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+const (
+	AMIPrefix          = "/dev"
+	AMIClass           = "ami"
+	AMILogicUUIDFile   = "logic_uuid"
+	AMIBoardSerialFile = "board_serial"
+)
+
+// Return the content of an optional sysfs attribute, empty if it is not exposed
+func getOptionalFileContent(file string) string {
+	if !fileExist(file) {
+		return ""
+	}
+	content, err := getFileContent(file)
+	if err != nil {
+		return ""
+	}
+	return content
+}
+
+/*
+Backend of newer cards like V70 and V80 driven by AMI. There is no separate
+user and management function, and no rom or xmc folder: each PCIe function
+bound to ami has a character device like /dev/ami0, which is injected as the
+user function node, and the logic uuid takes the place of the shell version.
+*/
+type amiBackend struct{}
+
+func (b amiBackend) Name() string {
+	return amiDriver
+}
+
+// Return a list of Xilinx devices driven by ami on host.
+func (b amiBackend) Discover() ([]xilinxDevice, error) {
+	if !fileExist(hostPath(SysfsDevices)) {
+		return nil, nil
+	}
+	pciFiles, err := ioutil.ReadDir(hostPath(SysfsDevices))
+	if err != nil {
+		return nil, fmt.Errorf("Can't read folder %s", SysfsDevices)
+	}
+
+	devices := []xilinxDevice{}
+	for _, pciFile := range pciFiles {
+		pciID := pciFile.Name()
+		if getPCIDriver(pciID) != amiDriver {
+			continue
+		}
+		vendorID, err := getFileContent(hostPath(path.Join(SysfsDevices, pciID, VendorFile)))
+		if err != nil {
+			return nil, err
+		}
+		if !isXilinxVendor(vendorID) {
+			continue
+		}
+
+		// The character device is created once the driver finished probing the function
+		node := ""
+		if classDir := hostPath(path.Join(SysfsDevices, pciID, AMIClass)); fileExist(classDir) {
+			node, err = getFileNameFromPrefix(classDir, AMIClass)
+			if err != nil {
+				return nil, err
+			}
+		}
+		devid, err := getFileContent(hostPath(path.Join(SysfsDevices, pciID, DeviceFile)))
+		if err != nil {
+			return nil, err
+		}
+
+		pair := &xilinxPair{}
+		state := deviceStateOffline
+		if node != "" {
+			pair.User = path.Join(AMIPrefix, node)
+			state = deviceStateReady
+		}
+		devices = append(devices, xilinxDevice{
+			shellVer: getOptionalFileContent(hostPath(path.Join(SysfsDevices, pciID, AMILogicUUIDFile))),
+			DBDF:     pciID,
+			deviceID: devid,
+			product:  catalog.getProduct(vendorID, devid),
+			SN:       getOptionalFileContent(hostPath(path.Join(SysfsDevices, pciID, AMIBoardSerialFile))),
+			Pair:     pair,
+			state:    state,
+		})
+	}
+	return devices, nil
+}
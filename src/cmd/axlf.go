@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+Layout of xclbin (axlf) file, refer to xclbin.h of XRT:
+
+	struct axlf {
+		char m_magic[8];                        // "xclbin2\0"
+		int32_t m_signature_length;
+		unsigned char reserved[28];
+		unsigned char m_keyBlock[256];
+		uint64_t m_uniqueId;
+		struct axlf_header m_header;            // offset 304
+		struct axlf_section_header m_sections[];// offset 456
+	};
+*/
+const (
+	AxlfMagic              = "xclbin2"
+	axlfHeaderOffset       = 304
+	axlfHeaderSize         = 152
+	axlfSectionHeaderSize  = 40
+	axlfPartitionMetadata  = 20
+	fdtMagic               = 0xd00dfeed
+	fdtBeginNode           = 1
+	fdtEndNode             = 2
+	fdtProp                = 3
+	fdtNop                 = 4
+	fdtEnd                 = 9
+	fdtInterfaceUUIDProp   = "interface_uuid"
+	axlfMaxPartitionLength = 1 << 20
+)
+
+// Information extracted from xclbin header
+type axlf struct {
+	length         uint64   // total size of xclbin file
+	timestamp      uint64   // seconds since epoch when xclbin was created
+	version        string   // xclbin version, like '2.13.466'
+	platformVBNV   string   // platform VBNV the xclbin was built for
+	uuid           string   // uuid of this xclbin
+	interfaceUUIDs []string // interface uuids of the platform, from partition metadata
+}
+
+type axlfSection struct {
+	kind   uint32
+	name   string
+	offset uint64
+	size   uint64
+}
+
+// Return the string from a null terminated byte array
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
+
+// Parse the header of xclbin file
+func parseAxlf(fname string) (*axlf, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open xclbin file %s", fname)
+	}
+	defer file.Close()
+
+	buf := make([]byte, axlfHeaderOffset+axlfHeaderSize)
+	if _, err := io.ReadFull(file, buf); err != nil {
+		return nil, fmt.Errorf("xclbin file %s is too short", fname)
+	}
+	if cString(buf[:8]) != AxlfMagic {
+		return nil, fmt.Errorf("%s is not a valid xclbin file", fname)
+	}
+
+	header := buf[axlfHeaderOffset:]
+	le := binary.LittleEndian
+	ret := &axlf{
+		length:    le.Uint64(header[0:8]),
+		timestamp: le.Uint64(header[8:16]),
+		version: fmt.Sprintf("%d.%d.%d",
+			header[26], header[27], le.Uint16(header[24:26])),
+		platformVBNV: cString(header[48:112]),
+		uuid:         hex.EncodeToString(header[112:128]),
+	}
+	numSections := le.Uint32(header[144:148])
+
+	for i := uint32(0); i < numSections; i++ {
+		section, err := readAxlfSection(file, i)
+		if err != nil {
+			return nil, fmt.Errorf("error reading section %d of %s: %v", i, fname, err)
+		}
+		if section.kind != axlfPartitionMetadata {
+			continue
+		}
+		if section.size > axlfMaxPartitionLength {
+			return nil, fmt.Errorf("partition metadata of %s is too large", fname)
+		}
+		data := make([]byte, section.size)
+		if _, err := file.ReadAt(data, int64(section.offset)); err != nil {
+			return nil, fmt.Errorf("error reading partition metadata of %s: %v", fname, err)
+		}
+		uuids, err := getFdtStringProps(data, fdtInterfaceUUIDProp)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing partition metadata of %s: %v", fname, err)
+		}
+		for _, uuid := range uuids {
+			ret.interfaceUUIDs = append(ret.interfaceUUIDs, normalizeUUID(uuid))
+		}
+	}
+
+	return ret, nil
+}
+
+// Read the section header with given index
+func readAxlfSection(file *os.File, index uint32) (*axlfSection, error) {
+	buf := make([]byte, axlfSectionHeaderSize)
+	offset := int64(axlfHeaderOffset + axlfHeaderSize + index*axlfSectionHeaderSize)
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	return &axlfSection{
+		kind:   le.Uint32(buf[0:4]),
+		name:   cString(buf[4:20]),
+		offset: le.Uint64(buf[24:32]),
+		size:   le.Uint64(buf[32:40]),
+	}, nil
+}
+
+// Return values of all string properties with given name in a flattened device tree
+func getFdtStringProps(data []byte, name string) ([]string, error) {
+	be := binary.BigEndian
+	if len(data) < 40 || be.Uint32(data[0:4]) != fdtMagic {
+		return nil, fmt.Errorf("invalid device tree magic")
+	}
+	structOff := be.Uint32(data[8:12])
+	stringsOff := be.Uint32(data[12:16])
+	if int(structOff) > len(data) || int(stringsOff) > len(data) {
+		return nil, fmt.Errorf("invalid device tree offsets")
+	}
+
+	var values []string
+	pos := int(structOff)
+	for pos+4 <= len(data) {
+		token := be.Uint32(data[pos : pos+4])
+		pos += 4
+		switch token {
+		case fdtBeginNode:
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated node name")
+			}
+			pos += (end + 4) &^ 3
+		case fdtProp:
+			if pos+8 > len(data) {
+				return nil, fmt.Errorf("truncated property")
+			}
+			length := int(be.Uint32(data[pos : pos+4]))
+			nameOff := int(stringsOff) + int(be.Uint32(data[pos+4:pos+8]))
+			pos += 8
+			if length < 0 || pos+length > len(data) || nameOff >= len(data) {
+				return nil, fmt.Errorf("truncated property")
+			}
+			if cString(data[nameOff:]) == name {
+				values = append(values, cString(data[pos:pos+length]))
+			}
+			pos += (length + 3) &^ 3
+		case fdtEndNode, fdtNop:
+		case fdtEnd:
+			return values, nil
+		default:
+			return nil, fmt.Errorf("unknown device tree token %d", token)
+		}
+	}
+	return nil, fmt.Errorf("unterminated device tree")
+}
+
+// Return UUID in lower case without dashes, so that they can be compared
+func normalizeUUID(uuid string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(uuid), "-", ""))
+}
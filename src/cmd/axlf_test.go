@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Build a device tree with a single 'interfaces' node holding an interface_uuid property
+func buildFdt(uuid string) []byte {
+	be := binary.BigEndian
+	pad := func(b []byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		be.PutUint32(b, v)
+		return b
+	}
+
+	strs := []byte(fdtInterfaceUUIDProp + "\x00")
+	var st []byte
+	st = append(st, u32(fdtBeginNode)...)
+	st = append(st, pad([]byte("\x00"))...)
+	st = append(st, u32(fdtBeginNode)...)
+	st = append(st, pad([]byte("interfaces\x00"))...)
+	value := []byte(uuid + "\x00")
+	st = append(st, u32(fdtProp)...)
+	st = append(st, u32(uint32(len(value)))...)
+	st = append(st, u32(0)...)
+	st = append(st, pad(value)...)
+	st = append(st, u32(fdtEndNode)...)
+	st = append(st, u32(fdtEndNode)...)
+	st = append(st, u32(fdtEnd)...)
+
+	header := make([]byte, 40)
+	be.PutUint32(header[0:4], fdtMagic)
+	be.PutUint32(header[8:12], 40)
+	be.PutUint32(header[12:16], uint32(40+len(st)))
+	data := append(header, st...)
+	data = append(data, strs...)
+	be.PutUint32(data[4:8], uint32(len(data)))
+	return data
+}
+
+// Build an xclbin with given platform VBNV and one partition metadata section
+func buildXclbin(vbnv string, uuid string) []byte {
+	le := binary.LittleEndian
+	fdt := buildFdt(uuid)
+	sectionOffset := axlfHeaderOffset + axlfHeaderSize + axlfSectionHeaderSize
+	buf := make([]byte, sectionOffset+len(fdt))
+
+	copy(buf, AxlfMagic)
+	header := buf[axlfHeaderOffset:]
+	le.PutUint64(header[0:8], uint64(len(buf)))
+	le.PutUint64(header[8:16], 1640995200)
+	le.PutUint16(header[24:26], 466)
+	header[26] = 2
+	header[27] = 13
+	copy(header[48:112], vbnv)
+	copy(header[112:128], []byte{0xde, 0xad, 0xbe, 0xef})
+	le.PutUint32(header[144:148], 1)
+
+	section := buf[axlfHeaderOffset+axlfHeaderSize:]
+	le.PutUint32(section[0:4], axlfPartitionMetadata)
+	copy(section[4:20], "partition")
+	le.PutUint64(section[24:32], uint64(sectionOffset))
+	le.PutUint64(section[32:40], uint64(len(fdt)))
+	copy(buf[sectionOffset:], fdt)
+	return buf
+}
+
+func TestParseAxlf(t *testing.T) {
+	fname := path.Join(t.TempDir(), "test.xclbin")
+	vbnv := "xilinx_u250_gen3x16_xdma_shell_3_1"
+	uuid := "2D0D2A38-4F2B-4B21-A0B7-C6B8E7E1C5A3"
+	require.NoError(t, os.WriteFile(fname, buildXclbin(vbnv, uuid), 0644))
+
+	xclbin, err := parseAxlf(fname)
+	require.NoError(t, err)
+	require.Equal(t, vbnv, xclbin.platformVBNV)
+	require.Equal(t, "2.13.466", xclbin.version)
+	require.Equal(t, "deadbeef000000000000000000000000", xclbin.uuid)
+	require.Equal(t, []string{normalizeUUID(uuid)}, xclbin.interfaceUUIDs)
+
+	require.NoError(t, checkXclbinOnDevice(xclbin, xilinxDevice{shellVer: vbnv}))
+	require.Error(t, checkXclbinOnDevice(xclbin, xilinxDevice{shellVer: "xilinx_u50_gen3x16_xdma_base_5"}))
+}
+
+func TestParseInvalidAxlf(t *testing.T) {
+	fname := path.Join(t.TempDir(), "invalid.xclbin")
+	require.NoError(t, os.WriteFile(fname, make([]byte, 1024), 0644))
+
+	_, err := parseAxlf(fname)
+	require.Error(t, err)
+}
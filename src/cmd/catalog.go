@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+const (
+	catalogVendorsKey  = "catalog.vendors"
+	catalogProductsKey = "catalog.products"
+	productSelector    = "product="
+)
+
+// A product with the PCI device ids of its functions, like the user and management functions of u250
+type xilinxProduct struct {
+	name        string
+	vendorID    string
+	deviceIDs   []string
+	cardDevices int // devices on one card, like the two devices of u30 under its on-card switch, 1 if not set
+}
+
+// Catalog of PCI vendors whose functions are discovered, and of known products
+type productCatalog struct {
+	vendorIDs []string
+	products  []xilinxProduct
+}
+
+// Vendors and products known without configuration
+var defaultCatalog = productCatalog{
+	vendorIDs: []string{XilinxVendorID, AristaVendorID, AWSVendorID, AdvantechVendorID},
+	products: []xilinxProduct{
+		{name: "u200", vendorID: XilinxVendorID, deviceIDs: []string{"0x5000", "0x5001"}},
+		{name: "u250", vendorID: XilinxVendorID, deviceIDs: []string{"0x5004", "0x5005"}},
+		{name: "u280", vendorID: XilinxVendorID, deviceIDs: []string{"0x500c", "0x500d"}},
+		{name: "u50", vendorID: XilinxVendorID, deviceIDs: []string{"0x5020", "0x5021"}},
+		{name: "u30", vendorID: XilinxVendorID, deviceIDs: []string{"0x503c", "0x503d"}, cardDevices: 2},
+		{name: "u55c", vendorID: XilinxVendorID, deviceIDs: []string{"0x505c", "0x505d"}},
+		{name: "v80", vendorID: XilinxVendorID, deviceIDs: []string{"0x50b4", "0x50b5"}},
+	},
+}
+
+// Catalog used by device discovery, extended by config
+var catalog = defaultCatalog
+
+// check if functions of the PCI vendor are discovered
+func (c productCatalog) hasVendor(vendorID string) bool {
+	for _, id := range c.vendorIDs {
+		if strings.EqualFold(id, vendorID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the product name of a PCI function, empty if it is not in the catalog
+func (c productCatalog) getProduct(vendorID string, deviceID string) string {
+	for _, product := range c.products {
+		if !strings.EqualFold(product.vendorID, vendorID) {
+			continue
+		}
+		for _, id := range product.deviceIDs {
+			if strings.EqualFold(id, deviceID) {
+				return product.name
+			}
+		}
+	}
+	return ""
+}
+
+// Return the number of devices on one card of the product
+func (c productCatalog) getCardDevices(name string) int {
+	for _, product := range c.products {
+		if product.name == name && product.cardDevices > 1 {
+			return product.cardDevices
+		} else if product.name == name {
+			break
+		}
+	}
+	return 1
+}
+
+/*
+Return the default catalog extended by the 'catalog' section of config.
+Configured products take precedence over default ones with the same ids,
+and their vendors are discovered as well, like for OEM rebadged cards.
+*/
+func getCatalog(tree *toml.Tree) productCatalog {
+	c := productCatalog{
+		vendorIDs: append([]string{}, defaultCatalog.vendorIDs...),
+	}
+	for _, vendorID := range getConfigStrings(tree, catalogVendorsKey, []string{}) {
+		if !c.hasVendor(vendorID) {
+			c.vendorIDs = append(c.vendorIDs, vendorID)
+		}
+	}
+
+	if products, ok := tree.Get(catalogProductsKey).([]*toml.Tree); ok {
+		for _, product := range products {
+			name, _ := product.GetDefault("name", "").(string)
+			vendorID, _ := product.GetDefault("vendor", "").(string)
+			deviceIDs := getConfigStrings(product, "devices", []string{})
+			cardDevices, _ := product.GetDefault("card-devices", int64(1)).(int64)
+			if name == "" || vendorID == "" || len(deviceIDs) == 0 {
+				logger.Printf("Ignoring incomplete product '%s' in catalog", name)
+				continue
+			}
+			c.products = append(c.products, xilinxProduct{
+				name:        name,
+				vendorID:    vendorID,
+				deviceIDs:   deviceIDs,
+				cardDevices: int(cardDevices),
+			})
+			if !c.hasVendor(vendorID) {
+				c.vendorIDs = append(c.vendorIDs, vendorID)
+			}
+		}
+	}
+	c.products = append(c.products, defaultCatalog.products...)
+	return c
+}
+
+// Return the product name of a device for display, '-' if unknown
+func getProductName(device xilinxDevice) string {
+	if device.product == "" {
+		return "-"
+	}
+	return device.product
+}
+
+// check if the device is matched by a product selector, like 'product=u30'
+func matchProduct(device xilinxDevice, selector string) bool {
+	if !strings.HasPrefix(selector, productSelector) {
+		return false
+	}
+	// Virtual functions are selected through their physical functions
+	if device.physFn != "" || device.product == "" {
+		return false
+	}
+	return strings.EqualFold(device.product, strings.TrimPrefix(selector, productSelector))
+}
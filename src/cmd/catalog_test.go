@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCatalog(t *testing.T) {
+	tree, err := toml.Load(`
+[catalog]
+vendors = ["0x1234"]
+
+[[catalog.products]]
+name = "oem-u250"
+vendor = "0x4321"
+devices = ["0x5005"]
+
+[[catalog.products]]
+name = "my-u250"
+vendor = "0x10ee"
+devices = ["0x5005"]
+
+[[catalog.products]]
+name = "my-u30"
+vendor = "0x10ee"
+devices = ["0x503c", "0x503d"]
+card-devices = 2
+
+[[catalog.products]]
+name = "incomplete"
+`)
+	require.Nil(t, err)
+	c := getCatalog(tree)
+
+	testCases := []struct {
+		vendorID string
+		deviceID string
+		vendor   bool
+		product  string
+	}{
+		{
+			vendorID: "0x10EE",
+			deviceID: "0x5001",
+			vendor:   true,
+			product:  "u200",
+		},
+		{
+			vendorID: "0x10ee",
+			deviceID: "0x5005",
+			vendor:   true,
+			product:  "my-u250",
+		},
+		{
+			vendorID: "0x4321",
+			deviceID: "0x5005",
+			vendor:   true,
+			product:  "oem-u250",
+		},
+		{
+			vendorID: "0x1234",
+			deviceID: "0x0001",
+			vendor:   true,
+		},
+		{
+			vendorID: "0x8086",
+			deviceID: "0x5005",
+		},
+	}
+
+	for i, tc := range testCases {
+		require.Equalf(t, tc.vendor, c.hasVendor(tc.vendorID), "%d: %v", i, tc)
+		require.Equalf(t, tc.product, c.getProduct(tc.vendorID, tc.deviceID), "%d: %v", i, tc)
+	}
+	require.Equal(t, 2, c.getCardDevices("my-u30"))
+	require.Equal(t, 2, c.getCardDevices("u30"))
+	require.Equal(t, 1, c.getCardDevices("my-u250"))
+	require.Equal(t, 1, c.getCardDevices("unknown"))
+	require.Equal(t, len(defaultCatalog.products)+3, len(c.products))
+}
+
+func TestSelectProduct(t *testing.T) {
+	devices := []xilinxDevice{
+		{index: "0", DBDF: "0000:00:1e.0", product: "u30", Pair: &xilinxPair{}},
+		{index: "1", DBDF: "0000:00:1f.0", product: "u30", Pair: &xilinxPair{}},
+		{index: "2", DBDF: "0000:3b:00.1", product: "u250", Pair: &xilinxPair{}},
+		{index: "3", DBDF: "0000:3b:00.4", product: "u250", physFn: "0000:3b:00.1", Pair: &xilinxPair{}},
+		{index: "4", DBDF: "0000:81:00.0", Pair: &xilinxPair{}},
+	}
+
+	testCases := []struct {
+		selector string
+		expected []string
+	}{
+		{
+			selector: "product=u30",
+			expected: []string{"0000:00:1e.0", "0000:00:1f.0"},
+		},
+		{
+			selector: "product=U250",
+			expected: []string{"0000:3b:00.1"},
+		},
+		{
+			selector: "product=",
+			expected: []string{},
+		},
+	}
+
+	for i, tc := range testCases {
+		selected, err := selectXilinxDevices(devices, tc.selector)
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		DBDFs := []string{}
+		for _, device := range selected {
+			DBDFs = append(DBDFs, device.DBDF)
+		}
+		require.Equalf(t, tc.expected, DBDFs, "%d: %v", i, tc)
+	}
+}
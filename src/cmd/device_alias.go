@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	envXLNXDeviceAlias = "XILINX_DEVICE_ALIAS"
+	aliasUserMinorBase = 128
+)
+
+// check if device node aliasing is enabled for this container
+func (r xilinxContainerRuntime) deviceAliasEnabled(spec *specs.Spec) bool {
+	// No device node is mounted in VFIO mode
+	if r.vfioEnabled(spec) {
+		return false
+	}
+	deviceAliasEnv := getSpecEnv(spec, envXLNXDeviceAlias)
+	if deviceAliasEnv != "" {
+		alias, err := strconv.ParseBool(strings.ToLower(deviceAliasEnv))
+		if err == nil {
+			return alias
+		}
+		r.logger.Printf("error getting device alias enable status %v", err)
+	}
+	return r.cfg.deviceAlias
+}
+
+// Return the user function node inside the container for the device with given allocation order
+func getUserNodeAlias(index int) string {
+	return path.Join(UserPrefix, DRMSTR+strconv.Itoa(aliasUserMinorBase+index))
+}
+
+// Return the user function node of the device inside the container
+func (r xilinxContainerRuntime) getContainerUserNode(spec *specs.Spec, device xilinxDevice, index int) string {
+	// Only DRM render nodes are renumbered, nodes of other driver families keep their names
+	if r.deviceAliasEnabled(spec) && path.Dir(device.Pair.User) == UserPrefix {
+		return getUserNodeAlias(index)
+	}
+	return device.Pair.User
+}
+
+/*
+Overlay the drm folder in the sysfs directory of an aliased device, so that
+XRT enumerating devices through sysfs finds the renumbered node instead of the
+host one. The folder is replaced by a tmpfs, where its entries are bind
+mounted back, with the host node renamed to the alias.
+*/
+func (r xilinxContainerRuntime) aliasSysfsNode(spec *specs.Spec, device xilinxDevice, node string) error {
+	drmDir := path.Join(getDeviceSysfsDir(device), "drm")
+	if node == device.Pair.User || mountExisted(spec, drmDir) {
+		return nil
+	}
+	files, err := ioutil.ReadDir(hostPath(drmDir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: drmDir,
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Options:     []string{"nosuid", "noexec", "nodev", "mode=755"},
+	})
+	for _, file := range files {
+		name := file.Name()
+		if name == path.Base(device.Pair.User) {
+			name = path.Base(node)
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: path.Join(drmDir, name),
+			Type:        "none",
+			Source:      path.Join(drmDir, file.Name()),
+			Options:     []string{"ro", "nosuid", "noexec", "nodev", "rbind"},
+		})
+	}
+	return nil
+}
+
+/*
+Renumber assigned devices as 0..n-1 inside the container. The devices held on
+host must be recorded in annotation, since XILINX_VISIBLE_DEVICES no longer
+refers to host devices afterwards.
+*/
+func (r xilinxContainerRuntime) aliasXilinxDevices(spec *specs.Spec, devices []xilinxDevice) {
+	if !r.deviceAliasEnabled(spec) {
+		return
+	}
+
+	DBDFs := []string{}
+	indices := []string{}
+	for i, device := range devices {
+		DBDFs = append(DBDFs, device.DBDF)
+		indices = append(indices, strconv.Itoa(i))
+	}
+	setSpecEnv(spec, envXLNXVisibleDevices, strings.Join(indices, ","))
+	r.logger.Infof("Device(s) %v renumbered as %v inside the container", DBDFs, indices)
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"path"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliasXilinxDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{},
+	}
+	devices := []xilinxDevice{
+		{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD131"}},
+		{DBDF: "0000:d8:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD130"}},
+	}
+
+	spec := &specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"XILINX_VISIBLE_DEVICES=2,3"},
+		},
+	}
+	shim.aliasXilinxDevices(spec, devices)
+	require.Equal(t, "2,3", getSpecEnv(spec, envXLNXVisibleDevices))
+	require.Equal(t, "/dev/dri/renderD130", shim.getContainerUserNode(spec, devices[1], 1))
+
+	spec.Process.Env = append(spec.Process.Env, "XILINX_DEVICE_ALIAS=true")
+	shim.aliasXilinxDevices(spec, devices)
+	require.Equal(t, "0,1", getSpecEnv(spec, envXLNXVisibleDevices))
+	require.Equal(t, "/dev/dri/renderD128", shim.getContainerUserNode(spec, devices[0], 0))
+	require.Equal(t, "/dev/dri/renderD129", shim.getContainerUserNode(spec, devices[1], 1))
+}
+
+func TestAliasSysfsNode(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{deviceAlias: true},
+	}
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.1/drm/renderD131":     "",
+		"sys/bus/pci/devices/0000:3b:00.1/drm/renderD131/dev": "226:131",
+		"sys/bus/pci/devices/0000:3b:00.1/drm/card0":          "",
+	})
+	sysfsDir := "/sys/bus/pci/devices/0000:3b:00.1"
+
+	testCases := []struct {
+		device       xilinxDevice
+		destinations []string
+	}{
+		{
+			device: xilinxDevice{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD131"}},
+			destinations: []string{
+				path.Join(sysfsDir, "drm"),
+				path.Join(sysfsDir, "drm/card0"),
+				path.Join(sysfsDir, "drm/renderD128"),
+			},
+		},
+		{
+			// nothing to overlay without a drm folder
+			device:       xilinxDevice{DBDF: "0000:5e:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD130"}},
+			destinations: []string{},
+		},
+	}
+
+	for i, tc := range testCases {
+		spec := &specs.Spec{}
+		node := shim.getContainerUserNode(spec, tc.device, 0)
+		require.NoErrorf(t, shim.aliasSysfsNode(spec, tc.device, node), "%d: %v", i, tc)
+
+		destinations := []string{}
+		for _, mount := range spec.Mounts {
+			destinations = append(destinations, mount.Destination)
+			if mount.Destination == path.Join(sysfsDir, "drm/renderD128") {
+				require.Equalf(t, path.Join(sysfsDir, "drm/renderD131"), mount.Source, "%d: %v", i, tc)
+			}
+		}
+		require.Equalf(t, tc.destinations, destinations, "%d: %v", i, tc)
+	}
+
+	// nodes are not overlaid without aliasing
+	spec := &specs.Spec{}
+	device := testCases[0].device
+	require.NoError(t, shim.aliasSysfsNode(spec, device, device.Pair.User))
+	require.Empty(t, spec.Mounts)
+}
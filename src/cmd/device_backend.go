@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"strconv"
+)
+
+const (
+	xoclDriver = "xocl"
+	amiDriver  = "ami"
+	zoclDriver = "zocl"
+)
+
+// discoveryBackend discovers Xilinx devices driven by one family of drivers
+type discoveryBackend interface {
+	// Name returns the driver family, recorded in discovered devices
+	Name() string
+	// Discover returns devices of the driver family on host, empty if the drivers are not loaded
+	Discover() ([]xilinxDevice, error)
+}
+
+// Backends in the order their devices are indexed
+var discoveryBackends = []discoveryBackend{
+	xoclBackend{},
+	amiBackend{},
+	zoclBackend{},
+}
+
+// Return a list of all Xilinx devices on host, scanned once per process.
+func getAllXilinxDevices() ([]xilinxDevice, error) {
+	return inventory.getDevices()
+}
+
+/*
+Return devices of all backends, numbering them in order, and the names of
+backends failing to discover devices. Failing backends are logged and
+skipped, so that one broken driver family does not hide the devices of others.
+*/
+func discoverXilinxDevices(backends []discoveryBackend) ([]xilinxDevice, []string) {
+	devices := []xilinxDevice{}
+	failed := []string{}
+	for _, backend := range backends {
+		discovered, err := backend.Discover()
+		if err != nil {
+			logger.Warnf("Skipping %s devices, error discovering them: %v", backend.Name(), err)
+			failed = append(failed, backend.Name())
+			continue
+		}
+		for _, device := range discovered {
+			device.index = strconv.Itoa(len(devices))
+			device.driver = backend.Name()
+			if device.state == "" {
+				device.state = deviceStateReady
+			}
+			devices = append(devices, device)
+		}
+	}
+	return devices, failed
+}
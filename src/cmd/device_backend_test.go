@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBackend struct {
+	name    string
+	devices []xilinxDevice
+	err     error
+}
+
+func (b fakeBackend) Name() string {
+	return b.name
+}
+
+func (b fakeBackend) Discover() ([]xilinxDevice, error) {
+	return b.devices, b.err
+}
+
+func TestDiscoverXilinxDevices(t *testing.T) {
+	backends := []discoveryBackend{
+		fakeBackend{
+			name: xoclDriver,
+			devices: []xilinxDevice{
+				{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD128"}},
+				{DBDF: "0000:d8:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD129"}},
+			},
+		},
+		fakeBackend{
+			name: amiDriver,
+			devices: []xilinxDevice{
+				{DBDF: "0000:81:00.0", Pair: &xilinxPair{User: "/dev/ami0"}},
+			},
+		},
+		fakeBackend{
+			name: zoclDriver,
+		},
+	}
+
+	devices, failed := discoverXilinxDevices(backends)
+	require.Empty(t, failed)
+	require.Equal(t, 3, len(devices))
+	for i, expected := range []struct {
+		DBDF   string
+		driver string
+	}{
+		{"0000:3b:00.1", xoclDriver},
+		{"0000:d8:00.1", xoclDriver},
+		{"0000:81:00.0", amiDriver},
+	} {
+		require.Equalf(t, fmt.Sprint(i), devices[i].index, "%d: %v", i, expected)
+		require.Equalf(t, expected.DBDF, devices[i].DBDF, "%d: %v", i, expected)
+		require.Equalf(t, expected.driver, devices[i].driver, "%d: %v", i, expected)
+	}
+
+	// Devices of other backends are still discovered when one fails
+	backends = append([]discoveryBackend{fakeBackend{name: "broken", err: fmt.Errorf("Can't read folder")}}, backends...)
+	devices, failed = discoverXilinxDevices(backends)
+	require.Equal(t, []string{"broken"}, failed)
+	require.Equal(t, 3, len(devices))
+	require.Equal(t, "0", devices[0].index)
+	require.Equal(t, "0000:3b:00.1", devices[0].DBDF)
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	envXLNXAssignedBDFs    = "XILINX_ASSIGNED_BDFS"
+	envXLNXAssignedSerials = "XILINX_ASSIGNED_SERIALS"
+	deviceMetadataFile     = "xilinx-devices.json"
+	deviceMetadataPath     = "/run/xilinx/devices.json"
+	deviceModeExclusive    = "exclusive"
+	deviceModeShared       = "shared"
+	annotationXLNXDevices  = "com.xilinx.devices.bdfs"
+	annotationXLNXMode     = "com.xilinx.devices.mode"
+	annotationXLNXVersion  = "com.xilinx.runtime.version"
+	annotationXLNXVFIO     = "com.xilinx.devices.vfio"
+)
+
+// Description of a device assigned to the container, as seen inside the container
+type containerDevice struct {
+	Index  int      `json:"index"`
+	BDF    string   `json:"bdf"`
+	Serial string   `json:"serial"`
+	Shell  string   `json:"shell"`
+	Nodes  []string `json:"nodes"`
+	Mode   string   `json:"mode"`
+}
+
+type containerDevices struct {
+	Devices []containerDevice `json:"devices"`
+}
+
+// Return the device usage mode of this container, either 'exclusive' or 'shared'
+func (r xilinxContainerRuntime) getDeviceMode(spec *specs.Spec) string {
+	if r.deviceExclusiveEnabled(spec) {
+		return deviceModeExclusive
+	}
+	return deviceModeShared
+}
+
+// describe assigned devices by environment variables and a generated read-only json file inside the container
+func (r xilinxContainerRuntime) addDeviceMetadata(spec *specs.Spec, devices []xilinxDevice) error {
+	mode := r.getDeviceMode(spec)
+	metadata := containerDevices{Devices: []containerDevice{}}
+	DBDFs := []string{}
+	serials := []string{}
+	for i, device := range devices {
+		nodes := []string{}
+		if r.vfioEnabled(spec) {
+			nodes = append(nodes, r.getVFIONodes(device)...)
+		} else {
+			if strings.TrimSpace(device.Pair.User) != "" {
+				nodes = append(nodes, r.getContainerUserNode(spec, device, i))
+			}
+			if strings.TrimSpace(device.Pair.Mgmt) != "" {
+				nodes = append(nodes, device.Pair.Mgmt)
+			}
+		}
+		metadata.Devices = append(metadata.Devices, containerDevice{
+			Index:  i,
+			BDF:    device.DBDF,
+			Serial: device.SN,
+			Shell:  device.shellVer,
+			Nodes:  nodes,
+			Mode:   mode,
+		})
+		DBDFs = append(DBDFs, device.DBDF)
+		// Devices without serial number, like virtual functions, are left out of the list
+		if device.SN != "" {
+			serials = append(serials, device.SN)
+		}
+	}
+	setSpecEnv(spec, envXLNXAssignedBDFs, strings.Join(DBDFs, ","))
+	setSpecEnv(spec, envXLNXAssignedSerials, strings.Join(serials, ","))
+
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating device metadata: %v", err)
+	}
+	source := path.Join(r.bundleDir, deviceMetadataFile)
+	err = os.WriteFile(source, content, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing device metadata file: %v", err)
+	}
+
+	if !mountExisted(spec, deviceMetadataPath) {
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: deviceMetadataPath,
+			Type:        "none",
+			Source:      source,
+			Options:     []string{"ro", "nosuid", "noexec", "nodev", "bind"},
+		})
+	}
+	return nil
+}
+
+// record injected devices as annotations, which are read by later commands instead of environment variables
+func (r xilinxContainerRuntime) annotateXilinxDevices(spec *specs.Spec, devices []xilinxDevice) {
+	DBDFs := []string{}
+	for _, device := range devices {
+		DBDFs = append(DBDFs, device.DBDF)
+	}
+
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	spec.Annotations[annotationXLNXDevices] = strings.Join(DBDFs, ",")
+	spec.Annotations[annotationXLNXMode] = r.getDeviceMode(spec)
+	spec.Annotations[annotationXLNXVersion] = Version
+	spec.Annotations[annotationXLNXVFIO] = strconv.FormatBool(r.vfioEnabled(spec))
+}
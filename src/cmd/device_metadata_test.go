@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddDeviceMetadata(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger:    logger,
+		cfg:       &config{deviceExclusive: true},
+		bundleDir: t.TempDir(),
+	}
+	devices := []xilinxDevice{
+		{
+			DBDF:     "0000:3b:00.1",
+			SN:       "XFL1YV0M20E0",
+			shellVer: "xilinx_u30_gen3x4_base_1",
+			Pair:     &xilinxPair{User: "/dev/dri/renderD131", Mgmt: "/dev/xclmgmt15104"},
+		},
+	}
+	spec := &specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"XILINX_DEVICE_ALIAS=1"},
+		},
+	}
+
+	require.NoError(t, shim.addDeviceMetadata(spec, devices))
+	require.Equal(t, "0000:3b:00.1", getSpecEnv(spec, envXLNXAssignedBDFs))
+	require.Equal(t, "XFL1YV0M20E0", getSpecEnv(spec, envXLNXAssignedSerials))
+	require.True(t, mountExisted(spec, deviceMetadataPath))
+
+	content, err := os.ReadFile(path.Join(shim.bundleDir, deviceMetadataFile))
+	require.NoError(t, err)
+	metadata := containerDevices{}
+	require.NoError(t, json.Unmarshal(content, &metadata))
+	require.Equal(t, []containerDevice{
+		{
+			Index:  0,
+			BDF:    "0000:3b:00.1",
+			Serial: "XFL1YV0M20E0",
+			Shell:  "xilinx_u30_gen3x4_base_1",
+			Nodes:  []string{"/dev/dri/renderD128", "/dev/xclmgmt15104"},
+			Mode:   deviceModeExclusive,
+		},
+	}, metadata.Devices)
+}
+
+func TestAssignedSerials(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger:    logger,
+		cfg:       &config{},
+		bundleDir: t.TempDir(),
+	}
+
+	testCases := []struct {
+		serials  []string
+		expected string
+	}{
+		{
+			serials:  []string{"XFL1YV0M20E0", "", "XFL1YV0M20E1"},
+			expected: "XFL1YV0M20E0,XFL1YV0M20E1",
+		},
+		{
+			serials:  []string{"", ""},
+			expected: "",
+		},
+	}
+
+	for i, tc := range testCases {
+		devices := []xilinxDevice{}
+		for _, serial := range tc.serials {
+			devices = append(devices, xilinxDevice{SN: serial, Pair: &xilinxPair{}})
+		}
+		spec := &specs.Spec{Process: &specs.Process{}}
+		require.NoErrorf(t, shim.addDeviceMetadata(spec, devices), "%d: %v", i, tc)
+		require.Equalf(t, tc.expected, getSpecEnv(spec, envXLNXAssignedSerials), "%d: %v", i, tc)
+	}
+}
+
+func TestAnnotateXilinxDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{deviceExclusive: true},
+	}
+	devices := []xilinxDevice{{DBDF: "0000:3b:00.1"}, {DBDF: "0000:d8:00.1"}}
+	spec := &specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"XILINX_DEVICE_EXCLUSIVE=false"},
+		},
+	}
+
+	shim.annotateXilinxDevices(spec, devices)
+	require.Equal(t, "0000:3b:00.1,0000:d8:00.1", spec.Annotations[annotationXLNXDevices])
+	require.Equal(t, deviceModeShared, spec.Annotations[annotationXLNXMode])
+
+	// The recorded mode takes precedence over environment variables
+	spec.Process.Env = []string{}
+	require.False(t, shim.deviceExclusiveEnabled(spec))
+}
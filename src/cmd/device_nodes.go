@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Return device number of Linux from major and minor numbers
+func mkdev(major int64, minor int64) uint64 {
+	dev := (uint64(major) & 0x00000fff) << 8
+	dev |= (uint64(major) & 0xfffff000) << 32
+	dev |= (uint64(minor) & 0x000000ff) << 0
+	dev |= (uint64(minor) & 0xffffff00) << 12
+	return dev
+}
+
+// Create a character device node based on the 'dev' attribute in sysfs folder, if it does not exist
+func createDeviceNode(node string, sysfsDir string, uid int, gid int, mode os.FileMode) (bool, error) {
+	if fileExist(node) {
+		return false, nil
+	}
+
+	major, minor, err := getSysfsMajorMinor(sysfsDir)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.MkdirAll(path.Dir(node), 0755)
+	if err != nil {
+		return false, fmt.Errorf("error creating folder for device node %s: %v", node, err)
+	}
+	err = syscall.Mknod(node, syscall.S_IFCHR|uint32(mode.Perm()), int(mkdev(major, minor)))
+	if err != nil {
+		return false, fmt.Errorf("error creating device node %s: %v", node, err)
+	}
+	// Mode passed to mknod is masked by umask
+	err = os.Chmod(node, mode.Perm())
+	if err != nil {
+		return true, fmt.Errorf("error setting mode of device node %s: %v", node, err)
+	}
+	err = os.Chown(node, uid, gid)
+	if err != nil {
+		return true, fmt.Errorf("error setting owner of device node %s: %v", node, err)
+	}
+	return true, nil
+}
+
+// Return the sysfs folder of the character device with given name under a device, like drm/renderD128
+func getSysfsCharDevDir(sysfsDir string, name string) (string, error) {
+	matches, err := filepath.Glob(path.Join(sysfsDir, "*", name))
+	if err != nil || len(matches) == 0 {
+		return "", fmt.Errorf("Can't find %s in %s", name, sysfsDir)
+	}
+	return matches[0], nil
+}
+
+// create missing user and management function nodes of devices on host
+func (r xilinxContainerRuntime) createDeviceNodes(devices []xilinxDevice) error {
+	if !r.cfg.deviceNodesCreate {
+		return nil
+	}
+
+	mode, err := strconv.ParseUint(r.cfg.deviceNodesMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid device node mode '%s'", r.cfg.deviceNodesMode)
+	}
+	uid, gid := int(r.cfg.deviceNodesUID), int(r.cfg.deviceNodesGID)
+
+	for _, device := range devices {
+		nodes := map[string]string{}
+		if strings.TrimSpace(device.Pair.User) != "" && !fileExist(hostPath(device.Pair.User)) {
+			sysfsDir, err := getSysfsCharDevDir(hostPath(getDeviceSysfsDir(device)), path.Base(device.Pair.User))
+			if err != nil {
+				return err
+			}
+			nodes[device.Pair.User] = sysfsDir
+		}
+		if strings.TrimSpace(device.Pair.Mgmt) != "" && !fileExist(hostPath(device.Pair.Mgmt)) {
+			sysfsDir, err := getSysfsCharDevDir(hostPath(path.Join(SysfsDevices, device.Pair.MgmtDBDF)), path.Base(device.Pair.Mgmt))
+			if err != nil {
+				return err
+			}
+			nodes[device.Pair.Mgmt] = sysfsDir
+		}
+
+		for node, sysfsDir := range nodes {
+			created, err := createDeviceNode(hostPath(node), sysfsDir, uid, gid, os.FileMode(mode))
+			if err != nil {
+				return fmt.Errorf("device %s: %v", device.DBDF, err)
+			}
+			if created {
+				r.logger.Infof("Created device node %s of device %s", node, device.DBDF)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMkdev(t *testing.T) {
+	require.Equal(t, uint64(226<<8|128), mkdev(226, 128))
+	require.Equal(t, uint64(0x100000100100), mkdev(0x1001, 0x100))
+}
+
+func TestCreateExistingDeviceNode(t *testing.T) {
+	dir := t.TempDir()
+	node := path.Join(dir, "renderD128")
+	require.NoError(t, os.WriteFile(node, []byte{}, 0644))
+
+	created, err := createDeviceNode(node, dir, 0, 0, 0666)
+	require.NoError(t, err)
+	require.False(t, created)
+}
+
+func TestCreateDeviceNodesExisting(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{deviceNodesCreate: true, deviceNodesMode: "0666"},
+	}
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	// Existing nodes are used as they are, even without sysfs entries of character devices
+	writeSysfsFixture(t, hostRoot, map[string]string{
+		"dev/dri/renderD128":                   "node",
+		"dev/xclmgmt15104":                     "node",
+		"sys/bus/pci/devices/0000:3b:00.1/drm": "",
+	})
+
+	testCases := []struct {
+		device     xilinxDevice
+		shouldFail bool
+	}{
+		{
+			device: xilinxDevice{DBDF: "0000:3b:00.1", Pair: &xilinxPair{
+				User: "/dev/dri/renderD128", Mgmt: "/dev/xclmgmt15104", MgmtDBDF: "0000:3b:00.0",
+			}},
+		},
+		{
+			device:     xilinxDevice{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD129"}},
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		err := shim.createDeviceNodes([]xilinxDevice{tc.device})
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	deviceCommandBDF = "{bdf}"
+)
+
+// Return a string quoted for shell, like 'a b'
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+/*
+Run a command for a device by shell, killing it after timeout. Placeholders,
+like '{bdf}', are replaced by their values quoted for shell, so that paths
+with spaces are passed as single arguments.
+*/
+func runDeviceCommand(logger *log.Logger, command string, placeholders map[string]string, timeout time.Duration) error {
+	if strings.TrimSpace(command) == "" {
+		return fmt.Errorf("no command configured")
+	}
+	oldnew := []string{}
+	for placeholder, value := range placeholders {
+		oldnew = append(oldnew, placeholder, shellQuote(value))
+	}
+	command = strings.NewReplacer(oldnew...).Replace(command)
+
+	// Run the shell in its own process group, so that its children are killed with it on timeout
+	var output bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	logger.Infof("Running %s", command)
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", command, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		logger.Infof("Output of %s: %s", command, output.String())
+		return fmt.Errorf("%s timed out after %v", command, timeout)
+	}
+	logger.Infof("Output of %s: %s", command, output.String())
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", command, err)
+	}
+	return nil
+}
+
+/*
+Reset devices released by the last container, which have been marked as
+resetting while releasing them. Devices failing to reset are marked as
+failed, so that they are not given to other containers until an
+administrator resets them and removes the state from the device exclusion
+file.
+*/
+func (r xilinxContainerRuntime) resetDevices(devices []xilinxDevice) error {
+	if !r.cfg.deviceReset || len(devices) == 0 {
+		return nil
+	}
+
+	timeout := time.Duration(r.cfg.deviceResetTimeout) * time.Second
+	failed := make(map[string]bool)
+	for _, device := range devices {
+		r.logger.Infof("Resetting device %s", device.DBDF)
+		placeholders := map[string]string{deviceCommandBDF: device.DBDF}
+		err := runDeviceCommand(r.logger, r.cfg.deviceResetCommand, placeholders, timeout)
+		if err != nil {
+			// Failing here would block deleting the container, so only log the error
+			r.logger.Errorf("Fail to reset device %s: %v", device.DBDF, err)
+			failed[device.DBDF] = true
+		}
+	}
+
+	// The file may be updated by other containers while resetting, so read it again
+	return r.updateDeviceExclusions(func(exclusions *xilinxDeviceExclusions) error {
+		for _, device := range devices {
+			if failed[device.DBDF] {
+				exclusions.States[device.DBDF] = deviceStateFailed
+				continue
+			}
+			delete(exclusions.States, device.DBDF)
+			// Reset clears the xclbin loaded on the device
+			delete(exclusions.Xclbins, device.DBDF)
+		}
+		return nil
+	})
+}
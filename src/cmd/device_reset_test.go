@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResetDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			exclusionFilePath:  path.Join(t.TempDir(), "exclusion.json"),
+			deviceReset:        true,
+			deviceResetCommand: "true {bdf}",
+			deviceResetTimeout: 10,
+		},
+	}
+	devices := []xilinxDevice{{DBDF: "0000:3b:00.1"}}
+
+	exclusions, err := shim.getDeviceExclusions()
+	require.NoError(t, err)
+	exclusions.Xclbins["0000:3b:00.1"] = "deadbeef"
+	exclusions.States["0000:3b:00.1"] = deviceStateResetting
+	require.NoError(t, shim.setDeviceExclusions(exclusions))
+
+	// Creating containers fails immediately while the device is being reset
+	_, _, err = shim.waitDevicesReady(devices, 0)
+	require.Error(t, err)
+
+	require.NoError(t, shim.resetDevices(devices))
+	exclusions, _, err = shim.waitDevicesReady(devices, 0)
+	require.NoError(t, err)
+	require.Empty(t, exclusions.States)
+	require.Empty(t, exclusions.Xclbins)
+}
+
+func TestDeleteDeviceExclusionsReset(t *testing.T) {
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	inventory = &deviceInventory{backends: []discoveryBackend{fakeBackend{
+		devices: []xilinxDevice{{DBDF: "0000:3b:00.1", Pair: &xilinxPair{}, state: deviceStateReady}},
+	}}}
+	spec := &specs.Spec{
+		Process: &specs.Process{Env: []string{envXLNXVisibleDevices + "=0000:3b:00.1"}},
+	}
+
+	testCases := []struct {
+		command string
+		state   string
+	}{
+		{
+			// the device is marked as resetting before the reset command runs
+			command: "grep -q resetting {file}",
+			state:   "",
+		},
+		{
+			command: "false {bdf}",
+			state:   deviceStateFailed,
+		},
+	}
+
+	for i, tc := range testCases {
+		logger, _ := testlog.NewNullLogger()
+		exclusionFilePath := path.Join(t.TempDir(), "exclusion.json")
+		shim := xilinxContainerRuntime{
+			logger: logger,
+			cfg: &config{
+				exclusionFilePath:  exclusionFilePath,
+				deviceReset:        true,
+				deviceResetCommand: strings.ReplaceAll(tc.command, "{file}", exclusionFilePath),
+				deviceResetTimeout: 10,
+			},
+		}
+		exclusions, err := shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		exclusions.Devices["0000:3b:00.1"] = 1
+		require.NoErrorf(t, shim.setDeviceExclusions(exclusions), "%d: %v", i, tc)
+
+		require.NoErrorf(t, shim.deleteDeviceExclusions(spec), "%d: %v", i, tc)
+		exclusions, err = shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, 0, exclusions.Devices["0000:3b:00.1"], "%d: %v", i, tc)
+		require.Equalf(t, tc.state, exclusions.States["0000:3b:00.1"], "%d: %v", i, tc)
+
+		// failed devices are refused at once, without waiting for them
+		_, _, err = shim.waitDevicesReady([]xilinxDevice{{DBDF: "0000:3b:00.1"}}, time.Minute)
+		require.Equalf(t, tc.state == "", err == nil, "%d: %v", i, tc)
+	}
+}
+
+func TestRunDeviceCommand(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	dir := path.Join(t.TempDir(), "it's a dir")
+	writeSysfsFixture(t, dir, map[string]string{"test.xclbin": "xclbin"})
+
+	testCases := []struct {
+		command    string
+		shouldFail bool
+	}{
+		{
+			// placeholders with spaces and quotes are passed as single arguments
+			command: "test -f {xclbin} && test {bdf} = 0000:3b:00.1",
+		},
+		{
+			command:    "exit 1",
+			shouldFail: true,
+		},
+		{
+			// children of the shell are killed on timeout too
+			command:    "sleep 5 | cat",
+			shouldFail: true,
+		},
+		{
+			command:    " ",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		placeholders := map[string]string{
+			deviceCommandBDF:   "0000:3b:00.1",
+			xclbinLoaderXclbin: path.Join(dir, "test.xclbin"),
+		}
+		start := time.Now()
+		err := runDeviceCommand(logger, tc.command, placeholders, 200*time.Millisecond)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+		require.Lessf(t, time.Since(start), 2*time.Second, "%d: %v", i, tc)
+	}
+}
@@ -25,7 +25,6 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
 )
 
 const (
@@ -46,6 +45,7 @@ const (
 	VendorFile        = "vendor"
 	DeviceFile        = "device"
 	SNFile            = "serial_num"
+	InterfaceUUIDFile = "interface_uuids"
 	XilinxVendorID    = "0x10ee"
 	AdvantechVendorID = "0x13fe"
 	AWSVendorID       = "0x1d0f"
@@ -54,9 +54,10 @@ const (
 
 // Usually, there is management PF and uer PF within one Xilinx device
 type xilinxPair struct {
-	Mgmt string // Management function node
-	User string // User function node
-	Qdma string
+	Mgmt     string // Management function node
+	User     string // User function node
+	Qdma     string
+	MgmtDBDF string // Bus:Drive.Function notation of management function
 }
 
 type xilinxDevice struct {
@@ -67,12 +68,17 @@ type xilinxDevice struct {
 	deviceID  string      // device id
 	SN        string      // serial number
 	Pair      *xilinxPair // pair with UserPF and MgmtPF node
+	physFn    string      // DBDF of the physical function, only for SR-IOV virtual functions
+	driver    string      // driver family of the device, like xocl, ami or zocl
+	product   string      // product name from the catalog, like u30
+	state     string      // device state found by discovery, either ready or offline
 }
 
 // For some Xilinx card, like U30, there is multiple devices
 type xilinxCard struct {
 	index   int // integer numbered
 	devices []xilinxDevice
+	note    string // how devices were grouped into the card, empty if by serial number
 }
 
 func getInstance(DBDF string) (string, error) {
@@ -133,20 +139,64 @@ func fileExist(fname string) bool {
 }
 
 func isMgmtPf(pciID string) bool {
-	fname := path.Join(SysfsDevices, pciID, MgmtFile)
+	fname := hostPath(path.Join(SysfsDevices, pciID, MgmtFile))
 	return fileExist(fname)
 }
 
 func isUserPf(pciID string) bool {
-	fname := path.Join(SysfsDevices, pciID, UserFile)
+	fname := hostPath(path.Join(SysfsDevices, pciID, UserFile))
 	return fileExist(fname)
 }
 
-// Return a list of all Xilinx devices on host.
-func getAllXilinxDevices() ([]xilinxDevice, error) {
+// Return the interface uuids of the shell on a device, empty if not exposed by the driver
+func getInterfaceUUIDs(DBDF string) ([]string, error) {
+	fname := hostPath(path.Join(SysfsDevices, DBDF, InterfaceUUIDFile))
+	if !fileExist(fname) {
+		return nil, nil
+	}
+	content, err := getFileContent(fname)
+	if err != nil {
+		return nil, err
+	}
+	uuids := []string{}
+	for _, uuid := range strings.Fields(content) {
+		uuids = append(uuids, normalizeUUID(uuid))
+	}
+	return uuids, nil
+}
+
+// check if the PCI vendor id is one of Xilinx based devices in the catalog
+func isXilinxVendor(vendorID string) bool {
+	return catalog.hasVendor(vendorID)
+}
+
+// Return the driver bound to a PCI function, empty if not bound
+func getPCIDriver(DBDF string) string {
+	link, err := os.Readlink(hostPath(path.Join(SysfsDevices, DBDF, DriverLink)))
+	if err != nil {
+		return ""
+	}
+	return path.Base(link)
+}
+
+// Backend of PCIe devices driven by xocl and xclmgmt, pairing user and management functions
+type xoclBackend struct{}
+
+func (b xoclBackend) Name() string {
+	return xoclDriver
+}
+
+// Return a list of Xilinx devices driven by xocl on host.
+func (b xoclBackend) Discover() ([]xilinxDevice, error) {
+	// Embedded platforms have no PCI bus
+	if !fileExist(hostPath(SysfsDevices)) {
+		return nil, nil
+	}
+
 	var devices []xilinxDevice
+	var virtFns []xilinxDevice
 	pairMap := make(map[string]*xilinxPair)
-	pciFiles, err := ioutil.ReadDir(SysfsDevices)
+	pciFiles, err := ioutil.ReadDir(hostPath(SysfsDevices))
 	if err != nil {
 		return nil, fmt.Errorf("Can't read folder %s", SysfsDevices)
 	}
@@ -154,15 +204,26 @@ func getAllXilinxDevices() ([]xilinxDevice, error) {
 	for _, pciFile := range pciFiles {
 		pciID := pciFile.Name()
 
-		fname := path.Join(SysfsDevices, pciID, VendorFile)
+		fname := hostPath(path.Join(SysfsDevices, pciID, VendorFile))
 		vendorID, err := getFileContent(fname)
 		if err != nil {
 			return nil, err
 		}
-		if strings.EqualFold(vendorID, XilinxVendorID) != true &&
-			strings.EqualFold(vendorID, AristaVendorID) != true &&
-			strings.EqualFold(vendorID, AWSVendorID) != true &&
-			strings.EqualFold(vendorID, AdvantechVendorID) != true {
+		if !isXilinxVendor(vendorID) {
+			continue
+		}
+		// Functions of other driver families are discovered by their own backends
+		if getPCIDriver(pciID) == amiDriver {
+			continue
+		}
+
+		// Virtual functions are not paired with other functions of the card
+		if isVirtFn(pciID) {
+			virtFn, err := getVirtFnDevice(pciID)
+			if err != nil {
+				return nil, err
+			}
+			virtFns = append(virtFns, virtFn)
 			continue
 		}
 
@@ -177,68 +238,56 @@ func getAllXilinxDevices() ([]xilinxDevice, error) {
 
 		if isUserPf(pciID) { //user pf
 			userDBDF := pciID
-			romFolder, err := getFileNameFromPrefix(path.Join(SysfsDevices, pciID), ROMSTR)
-			count := 0
+			// The rom folder is missing while the device is not ready, like being reset, and its shell is unknown
+			romFolder, err := getFileNameFromPrefix(hostPath(path.Join(SysfsDevices, pciID)), ROMSTR)
 			if err != nil {
 				return nil, err
 			}
-			for romFolder == "" {
-				/*
-					Generally most FPGA device will be rested in 120 seconds, but u30 device will take longer
-					than expected, and during this period, the device is being marked as unavailable. Therefore,
-					we give 720 seconds waiting for the device availability.
-				*/
-				if count >= 36 {
-					break
+			state := deviceStateOffline
+			dsaVer, dsaTs := "", ""
+			if romFolder != "" {
+				state = deviceStateReady
+				// get dsa version
+				fname = hostPath(path.Join(SysfsDevices, pciID, romFolder, DSAverFile))
+				dsaVer, err = getFileContent(fname)
+				if err != nil {
+					return nil, err
 				}
-				time.Sleep(10 * time.Second)
-				romFolder, err = getFileNameFromPrefix(path.Join(SysfsDevices, pciID), ROMSTR)
-				if romFolder != "" {
-					time.Sleep(20 * time.Second)
-					break
+				// get dsa timestamp
+				fname = hostPath(path.Join(SysfsDevices, pciID, romFolder, DSAtsFile))
+				dsaTs, err = getFileContent(fname)
+				if err != nil {
+					return nil, err
 				}
-				fmt.Println(count, pciID, romFolder, err)
-				count += 1
-			}
-			SNFolder, err := getFileNameFromPrefix(path.Join(SysfsDevices, pciID), SNSTR)
-			if err != nil {
-				return nil, err
 			}
-			// get dsa version
-			fname = path.Join(SysfsDevices, pciID, romFolder, DSAverFile)
-			content, err := getFileContent(fname)
-			if err != nil {
-				return nil, err
-			}
-			dsaVer := content
-			// get dsa timestamp
-			fname = path.Join(SysfsDevices, pciID, romFolder, DSAtsFile)
-			content, err = getFileContent(fname)
+			SNFolder, err := getFileNameFromPrefix(hostPath(path.Join(SysfsDevices, pciID)), SNSTR)
 			if err != nil {
 				return nil, err
 			}
-			dsaTs := content
 			// get device id
-			fname = path.Join(SysfsDevices, pciID, DeviceFile)
-			content, err = getFileContent(fname)
+			fname = hostPath(path.Join(SysfsDevices, pciID, DeviceFile))
+			content, err := getFileContent(fname)
 			if err != nil {
 				return nil, err
 			}
 			devid := content
 			// get Serial Number
-			fname = path.Join(SysfsDevices, pciID, SNFolder, SNFile)
+			fname = hostPath(path.Join(SysfsDevices, pciID, SNFolder, SNFile))
 			content, err = getFileContent(fname)
 			SN := ""
 			if err == nil {
 				SN = content
 			}
-			// get user PF node
-			userpf, err := getFileNameFromPrefix(path.Join(SysfsDevices, pciID, UserPFKeyword), DRMSTR)
-			if err != nil {
-				return nil, err
+			// get user PF node, which is not created yet on offline devices
+			if drmDir := hostPath(path.Join(SysfsDevices, pciID, UserPFKeyword)); fileExist(drmDir) {
+				userpf, err := getFileNameFromPrefix(drmDir, DRMSTR)
+				if err != nil {
+					return nil, err
+				}
+				if userpf != "" {
+					pairMap[DBD].User = path.Join(UserPrefix, userpf)
+				}
 			}
-			userNode := path.Join(UserPrefix, userpf)
-			pairMap[DBD].User = userNode
 
 			//get qdma device node if it exists
 			instance, err := getInstance(userDBDF)
@@ -246,7 +295,7 @@ func getAllXilinxDevices() ([]xilinxDevice, error) {
 				return nil, err
 			}
 
-			qdmaFolder, err := getFileNameFromPrefix(path.Join(SysfsDevices, pciID), QDMASTR)
+			qdmaFolder, err := getFileNameFromPrefix(hostPath(path.Join(SysfsDevices, pciID)), QDMASTR)
 			if err != nil {
 				return nil, err
 			}
@@ -256,28 +305,30 @@ func getAllXilinxDevices() ([]xilinxDevice, error) {
 			}
 
 			devices = append(devices, xilinxDevice{
-				index:     strconv.Itoa(len(devices)),
 				shellVer:  dsaVer,
 				timestamp: dsaTs,
 				DBDF:      userDBDF,
 				deviceID:  devid,
+				product:   catalog.getProduct(vendorID, devid),
 				SN:        SN,
 				Pair:      pairMap[DBD],
+				state:     state,
 			})
 		} else if isMgmtPf(pciID) { //mgmt pf
 			// get mgmt instance
-			fname = path.Join(SysfsDevices, pciID, InstanceFile)
+			fname = hostPath(path.Join(SysfsDevices, pciID, InstanceFile))
 			content, err := getFileContent(fname)
 			if err != nil {
 				return nil, err
 			}
 			pairMap[DBD].Mgmt = MgmtPrefix + content
+			pairMap[DBD].MgmtDBDF = pciID
 		}
 	}
-	return devices, nil
+	return adoptVirtFns(devices, virtFns), nil
 }
 
-// Return a list of device based on device environment variable, like '0,1', 'all', etc.
+// Return a list of device based on device environment variable, like '0,1', 'all', '0000:3b:00.1', etc.
 func getXilinxDevicesByDeviceEnv(visibleDevicesEnv string) ([]xilinxDevice, error) {
 	allDevices, err := getAllXilinxDevices()
 	if err != nil {
@@ -287,11 +338,30 @@ func getXilinxDevicesByDeviceEnv(visibleDevicesEnv string) ([]xilinxDevice, erro
 		return allDevices, nil
 	}
 
+	return selectXilinxDevices(allDevices, visibleDevicesEnv)
+}
+
+// check if the device is matched by index, device id, serial number, DBDF or product
+func matchXilinxDevice(device xilinxDevice, selector string) bool {
+	return selector == device.index || selector == device.deviceID || selector == device.SN || selector == device.DBDF ||
+		matchProduct(device, selector)
+}
+
+// Return devices matched by a list of selectors, like '0,1' or '0/vf0'
+func selectXilinxDevices(allDevices []xilinxDevice, visibleDevicesEnv string) ([]xilinxDevice, error) {
 	visibleDevices := []xilinxDevice{}
 	parts := strings.Split(visibleDevicesEnv, ",")
 	for _, part := range parts {
+		if strings.Contains(part, virtFnSelector) {
+			virtFns, err := selectVirtFns(allDevices, part)
+			if err != nil {
+				return nil, err
+			}
+			visibleDevices = append(visibleDevices, virtFns...)
+			continue
+		}
 		for _, device := range allDevices {
-			if part == device.index || part == device.deviceID || part == device.SN {
+			if matchXilinxDevice(device, part) {
 				visibleDevices = append(visibleDevices, device)
 			}
 		}
@@ -302,38 +372,45 @@ func getXilinxDevicesByDeviceEnv(visibleDevicesEnv string) ([]xilinxDevice, erro
 
 // Return a list of all Xilinx cards on host
 func getAllXilinxCards() ([]xilinxCard, error) {
-	allDevices, err := getAllXilinxDevices()
-	if err != nil {
-		return nil, err
-	}
+	return inventory.getCards()
+}
 
+// Group devices into cards by their group keys, cards are numbered in the order of devices
+func groupXilinxCards(devices []xilinxDevice, groups map[string]cardGroup) []xilinxCard {
 	cards := []xilinxCard{}
 	m := make(map[string]int)
-	for _, device := range allDevices {
-		if strings.TrimSpace(device.SN) == "" {
-			// No serial number found, treated as a single device card
+	for _, device := range devices {
+		// Virtual functions are selected as devices, not as part of cards
+		if device.physFn != "" {
+			continue
+		}
+		group := groups[device.DBDF]
+		if group.key == "" {
+			// Neither serial number nor topology found, treated as a single device card
 			index := len(cards)
 			cards = append(cards, xilinxCard{
 				index: index,
 				devices: []xilinxDevice{
 					device,
 				},
+				note: group.note,
 			})
 		} else {
-			index, exisited := m[device.SN]
+			index, exisited := m[group.key]
 			if !exisited {
 				index = len(cards)
 				cards = append(cards, xilinxCard{
 					index:   index,
 					devices: []xilinxDevice{},
+					note:    group.note,
 				})
-				m[device.SN] = index
+				m[group.key] = index
 			}
 			cards[index].devices = append(cards[index].devices, device)
 		}
 	}
 
-	return cards, nil
+	return cards
 }
 
 // Return a list of devices baed on card number, like '0', '1', etc.
@@ -346,6 +423,9 @@ func getXilinxDevicesByCardNum(num int) ([]xilinxDevice, error) {
 	if num >= len(allcards) {
 		return nil, fmt.Errorf("card number %d not existed", num)
 	}
+	if note := allcards[num].note; note != "" {
+		logger.Infof("Card %d: %s", num, note)
+	}
 	return allcards[num].devices, nil
 }
 
@@ -374,6 +454,9 @@ func getXilinxDevicesByCardEnv(visibleCardsEnv string) ([]xilinxDevice, error) {
 
 // Return device major and minor numbers based on device path
 func getDeviceMajorMinor(devPath string) (int64, int64, error) {
+	if major, minor, ok := getSnapshotMajorMinor(devPath); ok {
+		return major, minor, nil
+	}
 	stat := syscall.Stat_t{}
 	err := syscall.Stat(devPath, &stat)
 	if err != nil {
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+/*
+Devices are 'ready' to be used, 'resetting' while the runtime resets them
+after release or 'failed' if the reset failed, both recorded in the device
+exclusion file, or 'offline' while the driver does not expose them
+completely, like during a reset started outside the runtime.
+*/
+const (
+	deviceStateReady     = "ready"
+	deviceStateResetting = "resetting"
+	deviceStateFailed    = "failed"
+	deviceStateOffline   = "offline"
+)
+
+// Return the state of a device, combining discovery with the states recorded by the runtime
+func getDeviceState(device xilinxDevice, exclusions *xilinxDeviceExclusions) string {
+	if state := exclusions.States[device.DBDF]; state != "" {
+		return state
+	}
+	if device.state == "" {
+		return deviceStateReady
+	}
+	return device.state
+}
+
+// Return the readiness timeout configured for the runtime command in args, 0 if not configured
+func (r xilinxContainerRuntime) getReadinessTimeout(args []string) time.Duration {
+	var previousWasBundle bool
+	for _, a := range args {
+		if !previousWasBundle && isBundleFlag(a) {
+			previousWasBundle = true
+			continue
+		}
+		if timeout, ok := r.cfg.readinessTimeouts[a]; !previousWasBundle && ok {
+			return time.Duration(timeout) * time.Second
+		}
+		previousWasBundle = false
+	}
+	return 0
+}
+
+// Scan offline devices again, keeping devices not found as they are
+func refreshXilinxDevices(devices []xilinxDevice) []xilinxDevice {
+	offline := false
+	for _, device := range devices {
+		if device.state == deviceStateOffline {
+			offline = true
+			break
+		}
+	}
+	if !offline {
+		return devices
+	}
+
+	allDevices, err := inventory.rescan()
+	if err != nil {
+		return devices
+	}
+	refreshed := []xilinxDevice{}
+	for _, device := range devices {
+		for _, current := range allDevices {
+			if current.DBDF == device.DBDF {
+				device = current
+				break
+			}
+		}
+		refreshed = append(refreshed, device)
+	}
+	return refreshed
+}
+
+/*
+Wait until all devices are ready, and return the current device exclusion
+stats with the devices discovered again. It fails immediately if timeout is 0.
+*/
+func (r xilinxContainerRuntime) waitDevicesReady(devices []xilinxDevice, timeout time.Duration) (*xilinxDeviceExclusions, []xilinxDevice, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		exclusions, err := r.getDeviceExclusions()
+		if err != nil {
+			return nil, nil, err
+		}
+		devices = refreshXilinxDevices(devices)
+
+		notReady, state := "", ""
+		for _, device := range devices {
+			if state = getDeviceState(device, exclusions); state != deviceStateReady {
+				notReady = device.DBDF
+				break
+			}
+		}
+		if notReady == "" {
+			return exclusions, devices, nil
+		}
+
+		// Failed devices are not recovered by waiting
+		remaining := time.Until(deadline)
+		if remaining <= 0 || state == deviceStateFailed {
+			return nil, nil, fmt.Errorf("Device %s is %s", notReady, state)
+		}
+		r.logger.Infof("Waiting for device %s being %s, %v left", notReady, state, remaining.Round(time.Second))
+		time.Sleep(time.Second)
+	}
+}
+
+// Return a modifier waiting for the devices of the container to be ready, up to timeout
+func (r xilinxContainerRuntime) waitVisibleDevicesReady(timeout time.Duration) func(*specs.Spec) error {
+	return func(spec *specs.Spec) error {
+		visibleXilinxDevices, err := r.getVisibleDevices(spec)
+		if err != nil {
+			return err
+		}
+		_, _, err = r.waitDevicesReady(visibleXilinxDevices, timeout)
+		return err
+	}
+}
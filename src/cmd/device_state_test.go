@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pelletier/go-toml"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetReadinessTimeout(t *testing.T) {
+	tree, err := toml.Load(`
+[device-readiness]
+create = 720
+run = 60
+`)
+	require.Nil(t, err)
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			readinessTimeouts: getConfigTimeouts(tree, readinessKey),
+		},
+	}
+
+	testCases := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{
+			args:     []string{"runc", "create", "--bundle", "/foo/bar", "id"},
+			expected: 720 * time.Second,
+		},
+		{
+			args:     []string{"runc", "--bundle", "create", "run"},
+			expected: 60 * time.Second,
+		},
+		{
+			args:     []string{"runc", "--bundle", "create", "modify"},
+			expected: 0,
+		},
+	}
+
+	for i, tc := range testCases {
+		require.Equalf(t, tc.expected, shim.getReadinessTimeout(tc.args), "%d: %v", i, tc)
+	}
+}
+
+func TestWaitDevicesReady(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+		},
+	}
+
+	testCases := []struct {
+		devices    []xilinxDevice
+		states     map[string]string
+		shouldFail bool
+	}{
+		{
+			devices: []xilinxDevice{{DBDF: "0000:3b:00.1", state: deviceStateReady}, {DBDF: "0000:d8:00.1"}},
+		},
+		{
+			devices:    []xilinxDevice{{DBDF: "0000:3b:00.1", state: deviceStateReady}, {DBDF: "0000:d8:00.1", state: deviceStateOffline}},
+			shouldFail: true,
+		},
+		{
+			devices:    []xilinxDevice{{DBDF: "0000:3b:00.1", state: deviceStateReady}},
+			states:     map[string]string{"0000:3b:00.1": deviceStateResetting},
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		exclusions, err := shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		exclusions.States = tc.states
+		require.NoErrorf(t, shim.setDeviceExclusions(exclusions), "%d: %v", i, tc)
+
+		_, devices, err := shim.waitDevicesReady(tc.devices, 0)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, len(tc.devices), len(devices), "%d: %v", i, tc)
+	}
+}
+
+func TestAddDeviceExclusionsState(t *testing.T) {
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	inventory = &deviceInventory{backends: []discoveryBackend{fakeBackend{
+		devices: []xilinxDevice{{DBDF: "0000:3b:00.1", Pair: &xilinxPair{}, state: deviceStateReady}},
+	}}}
+	spec := &specs.Spec{
+		Process: &specs.Process{Env: []string{envXLNXVisibleDevices + "=0000:3b:00.1"}},
+	}
+
+	testCases := []struct {
+		state      string // state recorded while the exclusion file is locked by another runtime
+		users      int
+		shouldFail bool
+	}{
+		{
+			state: "",
+			users: 1,
+		},
+		{
+			state:      deviceStateResetting,
+			users:      0,
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		logger, _ := testlog.NewNullLogger()
+		shim := xilinxContainerRuntime{
+			logger: logger,
+			cfg: &config{
+				exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+			},
+		}
+
+		// The device is released for reset by another runtime while this one is claiming it
+		unlock, err := shim.lockDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		done := make(chan error)
+		go func() { done <- shim.addDeviceExclusions(spec) }()
+		time.Sleep(100 * time.Millisecond)
+		exclusions, err := shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		if tc.state != "" {
+			exclusions.States["0000:3b:00.1"] = tc.state
+		}
+		require.NoErrorf(t, shim.setDeviceExclusions(exclusions), "%d: %v", i, tc)
+		unlock()
+
+		err = <-done
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+		exclusions, err = shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, tc.users, exclusions.Devices["0000:3b:00.1"], "%d: %v", i, tc)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DriverLink = "driver"
+	DevFile    = "dev"
+	ReadyFile  = "ready"
+)
+
+// Return device major and minor numbers from the 'dev' attribute of a sysfs folder, like '226:128'
+func getSysfsMajorMinor(sysfsDir string) (int64, int64, error) {
+	content, err := getFileContent(path.Join(sysfsDir, DevFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	parts := strings.SplitN(content, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid device number '%s' in %s", content, sysfsDir)
+	}
+	major, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device number '%s' in %s", content, sysfsDir)
+	}
+	minor, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device number '%s' in %s", content, sysfsDir)
+	}
+	return major, minor, nil
+}
+
+// Return a list of problems found on the device, empty if it is ready to be used
+func validateXilinxDevice(device xilinxDevice) []string {
+	problems := []string{}
+	sysfsDir := hostPath(getDeviceSysfsDir(device))
+
+	if !fileExist(path.Join(sysfsDir, DriverLink)) {
+		problems = append(problems, "no driver bound")
+	}
+
+	if ready := path.Join(sysfsDir, ReadyFile); fileExist(ready) {
+		content, err := getFileContent(ready)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else if value, err := strconv.ParseInt(content, 0, 64); err != nil || value == 0 {
+			problems = append(problems, fmt.Sprintf("device not ready (%s)", content))
+		}
+	}
+
+	if strings.TrimSpace(device.Pair.User) == "" {
+		problems = append(problems, "no user function node found")
+	} else if major, minor, err := getDeviceMajorMinor(hostPath(device.Pair.User)); err != nil {
+		problems = append(problems, fmt.Sprintf("user function node %s not accessible: %v", device.Pair.User, err))
+	} else if charDevDir, err := getSysfsCharDevDir(sysfsDir, path.Base(device.Pair.User)); err != nil {
+		problems = append(problems, err.Error())
+	} else if sysfsMajor, sysfsMinor, err := getSysfsMajorMinor(charDevDir); err != nil {
+		problems = append(problems, err.Error())
+	} else if major != sysfsMajor || minor != sysfsMinor {
+		problems = append(problems, fmt.Sprintf("user function node %s is %d:%d, but sysfs reports %d:%d",
+			device.Pair.User, major, minor, sysfsMajor, sysfsMinor))
+	}
+
+	for _, node := range []string{device.Pair.Mgmt, device.Pair.Qdma} {
+		if strings.TrimSpace(node) != "" && !fileExist(hostPath(node)) {
+			problems = append(problems, fmt.Sprintf("device node %s not found", node))
+		}
+	}
+
+	return problems
+}
+
+// validate devices before injecting them, returning an error with diagnostics of every failed device
+func (r xilinxContainerRuntime) validateXilinxDevices(devices []xilinxDevice) error {
+	if !r.cfg.deviceValidation {
+		return nil
+	}
+
+	timeout := time.Duration(r.cfg.deviceValidationTimeout) * time.Second
+	diagnostics := []string{}
+	for _, device := range devices {
+		problems := validateXilinxDevice(device)
+		if len(problems) == 0 && strings.TrimSpace(r.cfg.deviceValidationCommand) != "" {
+			placeholders := map[string]string{deviceCommandBDF: device.DBDF}
+			err := runDeviceCommand(r.logger, r.cfg.deviceValidationCommand, placeholders, timeout)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("validation command: %v", err))
+			}
+		}
+		if len(problems) != 0 {
+			r.logger.Errorf("Device %s failed validation: %s", device.DBDF, strings.Join(problems, "; "))
+			diagnostics = append(diagnostics, fmt.Sprintf("device %s: %s", device.DBDF, strings.Join(problems, "; ")))
+		}
+	}
+
+	if len(diagnostics) != 0 {
+		return fmt.Errorf("device validation failed: %s", strings.Join(diagnostics, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSysfsMajorMinor(t *testing.T) {
+	testCases := []struct {
+		content    string
+		major      int64
+		minor      int64
+		shouldFail bool
+	}{
+		{
+			content: "226:128\n",
+			major:   226,
+			minor:   128,
+		},
+		{
+			content:    "226",
+			shouldFail: true,
+		},
+		{
+			content:    "a:b",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(path.Join(dir, DevFile), []byte(tc.content), 0644))
+
+		major, minor, err := getSysfsMajorMinor(dir)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equal(t, tc.major, major)
+		require.Equal(t, tc.minor, minor)
+	}
+}
+
+func TestValidateMissingDevice(t *testing.T) {
+	device := xilinxDevice{
+		DBDF: "ffff:ff:1f.7",
+		Pair: &xilinxPair{User: "/dev/dri/renderD999"},
+	}
+	require.NotEmpty(t, validateXilinxDevice(device))
+}
+
+func TestValidateXilinxDevice(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	defer func(nodes map[string][2]int64) { snapshotNodes = nodes }(snapshotNodes)
+
+	healthy := map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.1/driver":             "",
+		"sys/bus/pci/devices/0000:3b:00.1/ready":              "0x1",
+		"sys/bus/pci/devices/0000:3b:00.1/drm/renderD128/dev": "226:128",
+		"dev/dri/renderD128":                                  "node",
+		"dev/xclmgmt15104":                                    "node",
+	}
+	testCases := []struct {
+		files    map[string]string
+		problems []string
+	}{
+		{
+			files:    map[string]string{},
+			problems: []string{},
+		},
+		{
+			files:    map[string]string{"sys/bus/pci/devices/0000:3b:00.1/ready": "0"},
+			problems: []string{"device not ready (0)"},
+		},
+		{
+			files:    map[string]string{"sys/bus/pci/devices/0000:3b:00.1/drm/renderD128/dev": "226:129"},
+			problems: []string{"user function node /dev/dri/renderD128 is 226:128, but sysfs reports 226:129"},
+		},
+	}
+
+	for i, tc := range testCases {
+		hostRoot = t.TempDir()
+		writeSysfsFixture(t, hostRoot, healthy)
+		writeSysfsFixture(t, hostRoot, tc.files)
+		snapshotNodes = map[string][2]int64{hostPath("/dev/dri/renderD128"): {226, 128}}
+
+		// devices without shell information, like boards without ROM info, are still valid
+		device := xilinxDevice{
+			DBDF: "0000:3b:00.1",
+			Pair: &xilinxPair{User: "/dev/dri/renderD128", Mgmt: "/dev/xclmgmt15104"},
+		}
+		require.Equalf(t, tc.problems, validateXilinxDevice(device), "%d: %v", i, tc)
+	}
+
+	// the driver link is removed when the driver is unbound
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, healthy)
+	snapshotNodes = map[string][2]int64{hostPath("/dev/dri/renderD128"): {226, 128}}
+	require.NoError(t, os.Remove(path.Join(hostRoot, "sys/bus/pci/devices/0000:3b:00.1/driver")))
+	device := xilinxDevice{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD128"}}
+	require.Equal(t, []string{"no driver bound"}, validateXilinxDevice(device))
+}
+
+func TestValidateXilinxDevicesCommand(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	defer func(nodes map[string][2]int64) { snapshotNodes = nodes }(snapshotNodes)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.1/driver":             "",
+		"sys/bus/pci/devices/0000:3b:00.1/drm/renderD128/dev": "226:128",
+		"dev/dri/renderD128":                                  "node",
+	})
+	snapshotNodes = map[string][2]int64{hostPath("/dev/dri/renderD128"): {226, 128}}
+	devices := []xilinxDevice{{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD128"}}}
+
+	testCases := []struct {
+		enabled    bool
+		command    string
+		shouldFail bool
+	}{
+		{
+			enabled: true,
+			command: "test {bdf} = 0000:3b:00.1",
+		},
+		{
+			enabled:    true,
+			command:    "false",
+			shouldFail: true,
+		},
+		{
+			// nothing is validated unless enabled
+			enabled: false,
+			command: "false",
+		},
+	}
+
+	for i, tc := range testCases {
+		logger, _ := testlog.NewNullLogger()
+		shim := xilinxContainerRuntime{
+			logger: logger,
+			cfg: &config{
+				deviceValidation:        tc.enabled,
+				deviceValidationCommand: tc.command,
+				deviceValidationTimeout: 10,
+			},
+		}
+		err := shim.validateXilinxDevices(devices)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			require.Truef(t, strings.Contains(err.Error(), "validation command"), "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	envXLNXEmulation         = "XILINX_EMULATION"
+	envXLNXEmulationPlatform = "XILINX_EMULATION_PLATFORM"
+	envXLNXEmulationDevices  = "XILINX_EMULATION_DEVICES"
+	envXCLEmulationMode      = "XCL_EMULATION_MODE"
+	envEmconfigPath          = "EMCONFIG_PATH"
+	emulationSW              = "sw_emu"
+	emulationHW              = "hw_emu"
+	emconfigFile             = "emconfig.json"
+	emconfigDir              = "/run/xilinx/emconfig"
+)
+
+// emconfig.json read by XRT in emulation mode, as generated by emconfigutil
+type emconfig struct {
+	Platform emconfigPlatform `json:"Platform"`
+	Version  string           `json:"Version"`
+}
+
+type emconfigPlatform struct {
+	Boards []emconfigBoard `json:"Boards"`
+}
+
+type emconfigBoard struct {
+	Devices []emconfigDevice `json:"Devices"`
+	Name    string           `json:"Name"`
+}
+
+type emconfigDevice struct {
+	Name       string `json:"Name"`
+	NumDevices int    `json:"NumDevices"`
+}
+
+// Return the XRT emulation mode requested by the container, empty if not in emulation mode
+func getEmulationMode(spec *specs.Spec) string {
+	mode := getSpecEnv(spec, envXLNXEmulation)
+	if mode == "" {
+		mode = os.Getenv(envXLNXEmulation)
+	}
+	return mode
+}
+
+// check if the emulation mode is supported by XRT
+func checkEmulationMode(mode string) error {
+	if mode != emulationSW && mode != emulationHW {
+		return fmt.Errorf("unknown emulation mode '%s', either '%s' or '%s' allowed", mode, emulationSW, emulationHW)
+	}
+	return nil
+}
+
+// set up XRT emulation instead of injecting devices
+func (r xilinxContainerRuntime) addEmulation(spec *specs.Spec) error {
+	mode := getEmulationMode(spec)
+	if mode == "" {
+		return nil
+	}
+	if err := checkEmulationMode(mode); err != nil {
+		return err
+	}
+
+	platform := getSpecEnv(spec, envXLNXEmulationPlatform)
+	if platform == "" {
+		platform = r.cfg.emulationPlatform
+	}
+	if platform == "" {
+		return fmt.Errorf("no platform specified for emulation by env %s", envXLNXEmulationPlatform)
+	}
+	numDevices := 1
+	if devicesEnv := getSpecEnv(spec, envXLNXEmulationDevices); devicesEnv != "" {
+		n, err := strconv.Atoi(devicesEnv)
+		if err != nil || n < 1 {
+			return fmt.Errorf("only positive int numbers allowed for env %s", envXLNXEmulationDevices)
+		}
+		numDevices = n
+	}
+
+	config := emconfig{
+		Platform: emconfigPlatform{
+			Boards: []emconfigBoard{
+				{
+					Devices: []emconfigDevice{
+						{
+							Name:       platform,
+							NumDevices: numDevices,
+						},
+					},
+					// emconfigutil names the board after the platform too
+					Name: platform,
+				},
+			},
+		},
+		Version: "1.0",
+	}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error generating %s: %v", emconfigFile, err)
+	}
+	source := path.Join(r.bundleDir, emconfigFile)
+	err = os.WriteFile(source, content, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %v", emconfigFile, err)
+	}
+
+	sources := append([]string{source}, r.cfg.emulationMounts...)
+	destinations := append([]string{path.Join(emconfigDir, emconfigFile)}, r.cfg.emulationMounts...)
+	for i, destination := range destinations {
+		if mountExisted(spec, destination) {
+			continue
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: destination,
+			Type:        "none",
+			Source:      sources[i],
+			Options:     []string{"ro", "nosuid", "nodev", "rbind"},
+		})
+	}
+
+	setSpecEnv(spec, envXCLEmulationMode, mode)
+	setSpecEnv(spec, envEmconfigPath, emconfigDir)
+	r.logger.Infof("Running container in %s mode with %d %s device(s)", mode, numDevices, platform)
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddEmulation(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			emulationMounts: []string{"/opt/xilinx/xrt/lib/libxrt_swemu.so"},
+		},
+		bundleDir: t.TempDir(),
+	}
+
+	testCases := []struct {
+		env        []string
+		shouldAdd  bool
+		shouldFail bool
+	}{
+		{
+			env: []string{},
+		},
+		{
+			env:       []string{"XILINX_EMULATION=sw_emu", "XILINX_EMULATION_PLATFORM=xilinx_u250_gen3x16_xdma_4_1_202210_1", "XILINX_EMULATION_DEVICES=2"},
+			shouldAdd: true,
+		},
+		{
+			env:        []string{"XILINX_EMULATION=hw_emu"},
+			shouldFail: true,
+		},
+		{
+			env:        []string{"XILINX_EMULATION=emu", "XILINX_EMULATION_PLATFORM=xilinx_u250_gen3x16_xdma_4_1_202210_1"},
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		spec := &specs.Spec{
+			Process: &specs.Process{Env: tc.env},
+		}
+		err := shim.addEmulation(spec)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, tc.shouldAdd, mountExisted(spec, path.Join(emconfigDir, emconfigFile)), "%d: %v", i, tc)
+		if !tc.shouldAdd {
+			continue
+		}
+		require.True(t, mountExisted(spec, "/opt/xilinx/xrt/lib/libxrt_swemu.so"))
+		require.Equal(t, emulationSW, getSpecEnv(spec, envXCLEmulationMode))
+		require.Equal(t, emconfigDir, getSpecEnv(spec, envEmconfigPath))
+
+		content, err := os.ReadFile(path.Join(shim.bundleDir, emconfigFile))
+		require.NoError(t, err)
+		config := emconfig{}
+		require.NoError(t, json.Unmarshal(content, &config))
+		require.Equal(t, "1.0", config.Version)
+		require.Equal(t, 1, len(config.Platform.Boards))
+		board := config.Platform.Boards[0]
+		require.Equal(t, "xilinx_u250_gen3x16_xdma_4_1_202210_1", board.Name)
+		require.Equal(t, 1, len(board.Devices))
+		require.Equal(t, "xilinx_u250_gen3x16_xdma_4_1_202210_1", board.Devices[0].Name)
+		require.Equal(t, 2, board.Devices[0].NumDevices)
+	}
+}
+
+func TestEmulationSkipsDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{},
+	}
+
+	testCases := []struct {
+		mode       string
+		shouldFail bool
+	}{
+		{
+			mode: emulationSW,
+		},
+		{
+			mode: emulationHW,
+		},
+		{
+			// real devices are not skipped for unknown modes
+			mode:       "emu",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		spec := &specs.Spec{
+			Process: &specs.Process{Env: []string{"XILINX_EMULATION=" + tc.mode, "XILINX_VISIBLE_DEVICES=all"}},
+		}
+		devices, err := shim.getVisibleDevices(spec)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Emptyf(t, devices, "%d: %v", i, tc)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"path"
+)
+
+const (
+	envXLNXHostRoot = "XILINX_HOST_ROOT"
+)
+
+/*
+Prefix where /sys and /dev of the host are found, like /host when running in
+a container with the host root mounted, or a recorded sysfs tree. Empty when
+running on the host. Discovered device nodes and sysfs folders are kept
+host-absolute, so that paths injected into the OCI spec stay valid on host,
+and only files read or created by the runtime are looked up under the prefix.
+*/
+var hostRoot = ""
+
+// Return the host root from environment variable, or from config if not set
+func getHostRoot(configRoot string, envRoot string) string {
+	if envRoot != "" {
+		return envRoot
+	}
+	return configRoot
+}
+
+// Return where a host-absolute path, like /sys/bus/pci/devices, is found under the host root
+func hostPath(p string) string {
+	if hostRoot == "" {
+		return p
+	}
+	return path.Join(hostRoot, p)
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHostRoot(t *testing.T) {
+	testCases := []struct {
+		configRoot string
+		envRoot    string
+		expected   string
+	}{
+		{
+			expected: "",
+		},
+		{
+			configRoot: "/host",
+			expected:   "/host",
+		},
+		{
+			configRoot: "/host",
+			envRoot:    "/fixtures/u30",
+			expected:   "/fixtures/u30",
+		},
+	}
+
+	for i, tc := range testCases {
+		require.Equalf(t, tc.expected, getHostRoot(tc.configRoot, tc.envRoot), "%d: %v", i, tc)
+	}
+}
+
+// write files of a recorded sysfs tree under root
+func writeSysfsFixture(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		file := path.Join(root, name)
+		require.NoError(t, os.MkdirAll(path.Dir(file), 0755))
+		if content == "" {
+			require.NoError(t, os.MkdirAll(file, 0755))
+			continue
+		}
+		require.NoError(t, os.WriteFile(file, []byte(content+"\n"), 0644))
+	}
+}
+
+func TestDiscoverUnderHostRoot(t *testing.T) {
+	root := t.TempDir()
+	writeSysfsFixture(t, root, map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.0/vendor":             "0x10ee",
+		"sys/bus/pci/devices/0000:3b:00.0/device":             "0x503c",
+		"sys/bus/pci/devices/0000:3b:00.0/mgmt_pf":            "1",
+		"sys/bus/pci/devices/0000:3b:00.0/instance":           "15104",
+		"sys/bus/pci/devices/0000:3b:00.1/vendor":             "0x10ee",
+		"sys/bus/pci/devices/0000:3b:00.1/device":             "0x503d",
+		"sys/bus/pci/devices/0000:3b:00.1/user_pf":            "1",
+		"sys/bus/pci/devices/0000:3b:00.1/rom.u.1/VBNV":       "xilinx_u30_gen3x4_base_1",
+		"sys/bus/pci/devices/0000:3b:00.1/rom.u.1/timestamp":  "0x0000000000000001",
+		"sys/bus/pci/devices/0000:3b:00.1/xmc.u.2/serial_num": "XFL1YV0M20E0",
+		"sys/bus/pci/devices/0000:3b:00.1/drm/renderD128":     "",
+		"sys/bus/pci/devices/0000:3b:00.1/drm/renderD128/dev": "226:128",
+		"sys/bus/pci/devices/0000:3c:00.0/vendor":             "0x8086",
+	})
+
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = root
+
+	devices, failed := discoverXilinxDevices([]discoveryBackend{xoclBackend{}})
+	require.Empty(t, failed)
+	require.Equal(t, 1, len(devices))
+
+	device := devices[0]
+	require.Equal(t, "0000:3b:00.1", device.DBDF)
+	require.Equal(t, "xilinx_u30_gen3x4_base_1", device.shellVer)
+	require.Equal(t, "XFL1YV0M20E0", device.SN)
+	require.Equal(t, "u30", device.product)
+	require.Equal(t, deviceStateReady, device.state)
+	// Nodes and sysfs folders stay host-absolute to be injected into the OCI spec
+	require.Equal(t, "/dev/dri/renderD128", device.Pair.User)
+	require.Equal(t, "/dev/xclmgmt15104", device.Pair.Mgmt)
+	require.Equal(t, "/sys/bus/pci/devices/0000:3b:00.1", getDeviceSysfsDir(device))
+
+	charDevDir, err := getSysfsCharDevDir(hostPath(getDeviceSysfsDir(device)), path.Base(device.Pair.User))
+	require.NoError(t, err)
+	major, minor, err := getSysfsMajorMinor(charDevDir)
+	require.NoError(t, err)
+	require.Equal(t, int64(226), major)
+	require.Equal(t, int64(128), minor)
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+Directories whose modification times change when PCI functions are added or
+removed, drivers are bound or unbound, or device nodes are created, which
+invalidate the inventory cache. Only directories are checked, so that the
+cache stays cheaper than a scan, and changes not reflected in them, like a
+serial number readable after a card management controller update, are not
+seen until the cache file is removed.
+*/
+var inventoryWatchedDirs = []string{
+	SysfsDevices,
+	path.Join(SysfsPCIDrivers, "xocl"),
+	path.Join(SysfsPCIDrivers, "xclmgmt"),
+	path.Join(SysfsPCIDrivers, amiDriver),
+	path.Join(SysfsPCIDrivers, VFIODriver),
+	SysfsZoclDriver,
+	SysfsPlatformDevices,
+	SysfsSlots,
+	UserPrefix,
+	QdmaPrefix,
+	AMIPrefix,
+}
+
+// deviceInventory holds the devices and cards found by scanning the host once
+type deviceInventory struct {
+	backends  []discoveryBackend
+	cachePath string // inventory cache file, empty if disabled
+	devices   []xilinxDevice
+	cards     []xilinxCard
+}
+
+// Inventory shared by the whole process, devices are scanned on first use
+var inventory = &deviceInventory{backends: discoveryBackends}
+
+// inventoryDevice is a device as saved in the inventory cache file
+type inventoryDevice struct {
+	Index     string      `json:"index"`
+	ShellVer  string      `json:"shellVer"`
+	Timestamp string      `json:"timestamp"`
+	DBDF      string      `json:"dbdf"`
+	DeviceID  string      `json:"deviceId"`
+	SN        string      `json:"serialNum"`
+	Pair      *xilinxPair `json:"pair"`
+	PhysFn    string      `json:"physFn,omitempty"`
+	Driver    string      `json:"driver"`
+	Product   string      `json:"product,omitempty"`
+	State     string      `json:"state"`
+}
+
+// inventoryCache is the content of the inventory cache file
+type inventoryCache struct {
+	Notice      string            `json:"notice"`
+	Fingerprint map[string]int64  `json:"fingerprint"`
+	Devices     []inventoryDevice `json:"devices"`
+}
+
+// Return the modification times of watched directories and the catalog, identifying the host state the cache was made in
+func getInventoryFingerprint() map[string]int64 {
+	fingerprint := make(map[string]int64)
+	for _, dir := range inventoryWatchedDirs {
+		if info, err := os.Stat(hostPath(dir)); err == nil {
+			fingerprint[dir] = info.ModTime().UnixNano()
+		}
+	}
+	if files, err := ioutil.ReadDir(hostPath(SysfsDevices)); err == nil {
+		fingerprint["functions"] = int64(len(files))
+	}
+	// The host root and the catalog decide which functions are discovered
+	fingerprint["root:"+hostRoot] = 0
+	fingerprint[fmt.Sprintf("catalog:%v", catalog)] = 0
+	return fingerprint
+}
+
+// check if two fingerprints are the same
+func matchFingerprint(a map[string]int64, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Return devices from the cache file if it was saved in the same host state
+func (inv *deviceInventory) loadCache(fingerprint map[string]int64) ([]xilinxDevice, bool) {
+	content, err := os.ReadFile(inv.cachePath)
+	if err != nil {
+		return nil, false
+	}
+	cache := inventoryCache{}
+	if err = json.Unmarshal(content, &cache); err != nil {
+		logger.Warnf("Ignoring invalid inventory cache %s: %v", inv.cachePath, err)
+		return nil, false
+	}
+	if !matchFingerprint(cache.Fingerprint, fingerprint) {
+		return nil, false
+	}
+
+	devices := []xilinxDevice{}
+	for _, d := range cache.Devices {
+		devices = append(devices, xilinxDevice{
+			index:     d.Index,
+			shellVer:  d.ShellVer,
+			timestamp: d.Timestamp,
+			DBDF:      d.DBDF,
+			deviceID:  d.DeviceID,
+			SN:        d.SN,
+			Pair:      d.Pair,
+			physFn:    d.PhysFn,
+			driver:    d.Driver,
+			product:   d.Product,
+			state:     d.State,
+		})
+	}
+	return devices, true
+}
+
+// Save devices into the cache file, replacing it atomically for concurrent runtimes
+func (inv *deviceInventory) saveCache(fingerprint map[string]int64, devices []xilinxDevice) error {
+	cache := inventoryCache{
+		Notice:      "This file caches Xilinx devices discovered on host, and is discarded when devices, drivers or device nodes change.",
+		Fingerprint: fingerprint,
+		Devices:     []inventoryDevice{},
+	}
+	for _, device := range devices {
+		cache.Devices = append(cache.Devices, inventoryDevice{
+			Index:     device.index,
+			ShellVer:  device.shellVer,
+			Timestamp: device.timestamp,
+			DBDF:      device.DBDF,
+			DeviceID:  device.deviceID,
+			SN:        device.SN,
+			Pair:      device.Pair,
+			PhysFn:    device.physFn,
+			Driver:    device.driver,
+			Product:   device.product,
+			State:     device.state,
+		})
+	}
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(path.Dir(inv.cachePath), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(path.Dir(inv.cachePath), path.Base(inv.cachePath))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), inv.cachePath)
+}
+
+// check if devices can be cached, offline devices change without changing watched directories
+func cacheableDevices(devices []xilinxDevice) bool {
+	for _, device := range devices {
+		if device.state != deviceStateReady {
+			return false
+		}
+	}
+	return true
+}
+
+// Return all devices, scanning the host or reading the cache on first use
+func (inv *deviceInventory) getDevices() ([]xilinxDevice, error) {
+	if inv.devices == nil {
+		if err := inv.scan(true); err != nil {
+			return nil, err
+		}
+	}
+	return append([]xilinxDevice{}, inv.devices...), nil
+}
+
+// Scan the host for devices, reading them from the cache if allowed, and save them into the cache
+func (inv *deviceInventory) scan(readCache bool) error {
+	inv.devices, inv.cards = nil, nil
+
+	var fingerprint map[string]int64
+	if inv.cachePath != "" {
+		fingerprint = getInventoryFingerprint()
+		if readCache {
+			if devices, ok := inv.loadCache(fingerprint); ok {
+				inv.devices = devices
+				return nil
+			}
+		}
+	}
+
+	devices, failed := discoverXilinxDevices(inv.backends)
+	if len(failed) != 0 && len(failed) == len(inv.backends) {
+		return fmt.Errorf("error discovering devices of %s", strings.Join(failed, ", "))
+	}
+	// Devices of failed backends are not cached, but discovered again by the next runtime
+	if inv.cachePath != "" && cacheableDevices(devices) && len(failed) == 0 {
+		if err := inv.saveCache(fingerprint, devices); err != nil {
+			logger.Warnf("Fail to save inventory cache %s: %v", inv.cachePath, err)
+		}
+	}
+	inv.devices = devices
+	return nil
+}
+
+// Return all cards, grouping the devices on first use
+func (inv *deviceInventory) getCards() ([]xilinxCard, error) {
+	if inv.cards != nil {
+		return append([]xilinxCard{}, inv.cards...), nil
+	}
+	devices, err := inv.getDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	slots := getPCISlots()
+	cardBridges := getCardBridges(getPCIFunctions())
+	groups := make(map[string]cardGroup)
+	for _, device := range devices {
+		groups[device.DBDF] = getCardGroup(device, slots, cardBridges)
+	}
+	inv.cards = groupXilinxCards(devices, groups)
+	return append([]xilinxCard{}, inv.cards...), nil
+}
+
+// Scan the host again bypassing the cache, like while waiting for offline devices
+func (inv *deviceInventory) rescan() ([]xilinxDevice, error) {
+	if err := inv.scan(false); err != nil {
+		return nil, err
+	}
+	return append([]xilinxDevice{}, inv.devices...), nil
+}
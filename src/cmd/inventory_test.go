@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingBackend counts how many times devices are discovered
+type countingBackend struct {
+	fakeBackend
+	scans *int
+}
+
+func (b countingBackend) Discover() ([]xilinxDevice, error) {
+	*b.scans++
+	return b.fakeBackend.Discover()
+}
+
+func TestDeviceInventory(t *testing.T) {
+	devices := []xilinxDevice{
+		{DBDF: "0000:3b:00.1", SN: "XFL1", shellVer: "xilinx_u30_gen3x4_base_1", Pair: &xilinxPair{User: "/dev/dri/renderD128"}},
+		{DBDF: "0000:3c:00.1", SN: "XFL1", shellVer: "xilinx_u30_gen3x4_base_1", Pair: &xilinxPair{User: "/dev/dri/renderD129"}},
+		{DBDF: "0000:d8:00.1", state: deviceStateOffline},
+	}
+	cachePath := path.Join(t.TempDir(), "inventory", "inventory.json")
+
+	testCases := []struct {
+		devices   []xilinxDevice
+		cachePath string
+		// scans of the host by a first and a second process
+		expectedScans int
+	}{
+		{
+			devices:       devices[:2],
+			expectedScans: 2,
+		},
+		{
+			devices:       devices[:2],
+			cachePath:     cachePath,
+			expectedScans: 1,
+		},
+		{
+			// offline devices are not cached
+			devices:       devices,
+			cachePath:     path.Join(t.TempDir(), "inventory.json"),
+			expectedScans: 2,
+		},
+	}
+
+	for i, tc := range testCases {
+		scans := 0
+		backends := []discoveryBackend{
+			countingBackend{fakeBackend: fakeBackend{name: xoclDriver, devices: tc.devices}, scans: &scans},
+		}
+		var found []xilinxDevice
+		for process := 0; process < 2; process++ {
+			inv := &deviceInventory{backends: backends, cachePath: tc.cachePath}
+			for call := 0; call < 3; call++ {
+				var err error
+				found, err = inv.getDevices()
+				require.NoErrorf(t, err, "%d: %v", i, tc)
+			}
+		}
+		require.Equalf(t, tc.expectedScans, scans, "%d: %v", i, tc)
+		require.Equalf(t, len(tc.devices), len(found), "%d: %v", i, tc)
+		for j, device := range found {
+			require.Equalf(t, tc.devices[j].DBDF, device.DBDF, "%d: %v", i, tc)
+			require.Equalf(t, tc.devices[j].SN, device.SN, "%d: %v", i, tc)
+			require.Equalf(t, tc.devices[j].Pair, device.Pair, "%d: %v", i, tc)
+			require.Equalf(t, xoclDriver, device.driver, "%d: %v", i, tc)
+		}
+	}
+}
+
+func TestMatchFingerprint(t *testing.T) {
+	testCases := []struct {
+		a        map[string]int64
+		b        map[string]int64
+		expected bool
+	}{
+		{
+			a:        map[string]int64{SysfsDevices: 1, UserPrefix: 2},
+			b:        map[string]int64{SysfsDevices: 1, UserPrefix: 2},
+			expected: true,
+		},
+		{
+			a:        map[string]int64{SysfsDevices: 1, UserPrefix: 2},
+			b:        map[string]int64{SysfsDevices: 1, UserPrefix: 3},
+			expected: false,
+		},
+		{
+			a:        map[string]int64{SysfsDevices: 1},
+			b:        map[string]int64{SysfsDevices: 1, UserPrefix: 2},
+			expected: false,
+		},
+		{
+			a:        nil,
+			b:        map[string]int64{SysfsDevices: 1},
+			expected: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		require.Equalf(t, tc.expected, matchFingerprint(tc.a, tc.b), "%d: %v", i, tc)
+	}
+}
+
+func TestInventoryFingerprint(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.1/vendor": "0x10ee",
+		"sys/bus/pci/drivers/xocl":                "",
+		"dev/dri":                                 "",
+	})
+	past := time.Now().Add(-time.Hour)
+	for _, dir := range inventoryWatchedDirs {
+		if fileExist(hostPath(dir)) {
+			require.NoError(t, os.Chtimes(hostPath(dir), past, past))
+		}
+	}
+	fingerprint := getInventoryFingerprint()
+	require.True(t, matchFingerprint(fingerprint, getInventoryFingerprint()))
+
+	// files of functions are not part of the fingerprint
+	writeSysfsFixture(t, hostRoot, map[string]string{"sys/bus/pci/devices/0000:3b:00.1/vendor": "0x8086"})
+	require.True(t, matchFingerprint(fingerprint, getInventoryFingerprint()))
+
+	changes := []string{
+		"sys/bus/pci/devices/0000:5e:00.1/vendor",
+		"dev/dri/renderD128",
+		"dev/xfpga/qdma.u.24065",
+	}
+	for i, change := range changes {
+		writeSysfsFixture(t, hostRoot, map[string]string{change: ""})
+		changed := getInventoryFingerprint()
+		require.Falsef(t, matchFingerprint(fingerprint, changed), "%d: %v", i, change)
+		fingerprint = changed
+	}
+}
+
+func TestDeviceInventoryFailedBackend(t *testing.T) {
+	scans := 0
+	backends := []discoveryBackend{
+		fakeBackend{name: amiDriver, err: fmt.Errorf("Can't read folder")},
+		countingBackend{fakeBackend: fakeBackend{name: xoclDriver, devices: []xilinxDevice{{DBDF: "0000:3b:00.1"}}}, scans: &scans},
+	}
+	cachePath := path.Join(t.TempDir(), "inventory.json")
+
+	// Devices of other backends are found, but not cached while a backend fails
+	for process := 0; process < 2; process++ {
+		inv := &deviceInventory{backends: backends, cachePath: cachePath}
+		devices, err := inv.getDevices()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(devices))
+	}
+	require.Equal(t, 2, scans)
+	require.False(t, fileExist(cachePath))
+
+	// Discovery fails if no backend works
+	inv := &deviceInventory{backends: backends[:1]}
+	_, err := inv.getDevices()
+	require.Error(t, err)
+}
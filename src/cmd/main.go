@@ -22,23 +22,80 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 
 	"github.com/pborman/getopt"
 	"github.com/pelletier/go-toml"
 )
 
 type config struct {
-	debugFilePath     string
-	deviceExclusive   bool
-	exclusionFilePath string
+	debugFilePath           string
+	deviceExclusive         bool
+	exclusionFilePath       string
+	xrtHostPath             string
+	xrtICDPath              string
+	xrtVersionCheck         string
+	xclbinCheck             string
+	xclbinProgram           bool
+	xclbinLoader            string
+	xclbinLoadTimeout       int64
+	deviceReset             bool
+	deviceResetCommand      string
+	deviceResetTimeout      int64
+	deviceValidation        bool
+	deviceValidationCommand string
+	deviceValidationTimeout int64
+	deviceNodesCreate       bool
+	deviceNodesUID          int64
+	deviceNodesGID          int64
+	deviceNodesMode         string
+	numaPin                 bool
+	deviceAlias             bool
+	emulationPlatform       string
+	emulationMounts         []string
+	zoclMounts              []string
+	readinessTimeouts       map[string]int64
+	catalog                 productCatalog
+	vfio                    bool
+	vfioRebind              bool
+	inventoryCache          string
+	hostRoot                string
 }
 
 const (
-	configOverride       = "XCRT_CONFIG_HOME"
-	configFilePath       = "xilinx-container-runtime/config.toml"
-	debugFilePathKey     = "xilinx-container-runtime.debug"
-	deviceExclusiveKey   = "device-exclusion.enabled"
-	exclusionFilePathKey = "device-exclusion.filepath"
+	configOverride             = "XCRT_CONFIG_HOME"
+	configFilePath             = "xilinx-container-runtime/config.toml"
+	debugFilePathKey           = "xilinx-container-runtime.debug"
+	deviceExclusiveKey         = "device-exclusion.enabled"
+	exclusionFilePathKey       = "device-exclusion.filepath"
+	xrtHostPathKey             = "xrt.host-path"
+	xrtICDPathKey              = "xrt.icd-path"
+	xrtVersionCheckKey         = "xrt.version-check"
+	xclbinCheckKey             = "xclbin.check"
+	xclbinProgramKey           = "xclbin.program"
+	xclbinLoaderKey            = "xclbin.loader"
+	xclbinLoadTimeoutKey       = "xclbin.load-timeout"
+	deviceResetKey             = "device-reset.enabled"
+	deviceResetCommandKey      = "device-reset.command"
+	deviceResetTimeoutKey      = "device-reset.timeout"
+	deviceValidationKey        = "device-validation.enabled"
+	deviceValidationCommandKey = "device-validation.command"
+	deviceValidationTimeoutKey = "device-validation.timeout"
+	deviceNodesCreateKey       = "device-nodes.create"
+	deviceNodesUIDKey          = "device-nodes.uid"
+	deviceNodesGIDKey          = "device-nodes.gid"
+	deviceNodesModeKey         = "device-nodes.mode"
+	numaPinKey                 = "numa.pin"
+	deviceAliasKey             = "device-alias.enabled"
+	emulationPlatformKey       = "emulation.platform"
+	emulationMountsKey         = "emulation.mounts"
+	readinessKey               = "device-readiness"
+	zoclMountsKey              = "zocl.mounts"
+	vfioKey                    = "vfio.enabled"
+	vfioRebindKey              = "vfio.rebind"
+	inventoryCacheKey          = "inventory.cache"
+	hostRootKey                = "host.root"
 )
 
 var (
@@ -68,16 +125,19 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "   kill\t\tkill sends the specified signal (default: SIGTERM) to the container's init process\n")
 	fmt.Fprintf(os.Stderr, "   list\t\tlists containers started by runc with the given root\n")
 	fmt.Fprintf(os.Stderr, "   lscard\tlists xilinx cards in the host\n")
-	fmt.Fprintf(os.Stderr, "   lsdevice\tlists xilinx devices in the host\n")
+	fmt.Fprintf(os.Stderr, "   lsdevice\tlists xilinx devices in the host, '--output wide' shows NUMA node and PCIe topology\n")
 	fmt.Fprintf(os.Stderr, "   pause\tpause suspends all processes inside the container\n")
 	fmt.Fprintf(os.Stderr, "   ps\t\tps displays the processes running inside a container\n")
+	fmt.Fprintf(os.Stderr, "   replay\t<file> [bundle]: lists devices of a snapshot, and prints the OCI spec of the bundle modified against it\n")
 	fmt.Fprintf(os.Stderr, "   restore\trestore a container from a previous checkpoint\n")
 	fmt.Fprintf(os.Stderr, "   resume\tresumes all processes that have been previously paused\n")
 	fmt.Fprintf(os.Stderr, "   run\t\tcreate and run a container\n")
+	fmt.Fprintf(os.Stderr, "   snapshot\t[file]: saves sysfs and device node metadata of xilinx devices into a tarball\n")
 	fmt.Fprintf(os.Stderr, "   spec\t\tcreate a new specification file\n")
 	fmt.Fprintf(os.Stderr, "   start\texecutes the user defined process in a created container\n")
 	fmt.Fprintf(os.Stderr, "   state\toutput the state of a container\n")
 	fmt.Fprintf(os.Stderr, "   update\tupdate container resource constraints\n")
+	fmt.Fprintf(os.Stderr, "   xclbin\tinspect <file>: shows header information of xclbin files\n")
 	fmt.Fprintf(os.Stderr, "   help, h\tShows a list of commands or help for one command\n")
 	fmt.Fprintf(os.Stderr, "\nGLOBAL OPTIONS:\n")
 	fmt.Fprintf(os.Stderr, "   --debug\t\tenable debug output for logging\n")
@@ -100,17 +160,63 @@ func printVersion() {
 	fmt.Fprintf(os.Stderr, "   build time:\t%s\n", BuildTime)
 }
 
-func printDevices() {
+// Return output format from options like '--output wide', '--output=wide' or '-o wide'
+func getOutputFormat(args []string) string {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--output=") {
+			return strings.TrimPrefix(arg, "--output=")
+		}
+		if (arg == "--output" || arg == "-o") && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func printDevices(cfg *config, output string) {
 	xilinxDevices, err := getAllXilinxDevices()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 	}
 
-	fmt.Fprintf(os.Stderr, "DeviceIndex\tSerialNum\tDeviceBDF\tUserPF\t\t\tMgmtPF\t\t\tShellVersion\n")
+	// Devices being reset by the runtime are recorded in the device exclusion file
+	r := xilinxContainerRuntime{logger: logger.Logger, cfg: cfg}
+	exclusions, err := r.getDeviceExclusions()
+	if err != nil {
+		exclusions = &xilinxDeviceExclusions{States: make(map[string]string)}
+	}
+
+	if output != "wide" {
+		fmt.Fprintf(os.Stderr, "DeviceIndex\tSerialNum\tDeviceBDF\tUserPF\t\t\tMgmtPF\t\t\tShellVersion\t\t\t\tProduct\t\tState\n")
+		for _, xilinxDevice := range xilinxDevices {
+			fmt.Fprintf(os.Stderr, "%-16s%-16s%-16s%-24s%-24s%-40s%-16s%s\n",
+				xilinxDevice.index, xilinxDevice.SN, xilinxDevice.DBDF,
+				xilinxDevice.Pair.User, xilinxDevice.Pair.Mgmt, xilinxDevice.shellVer, getProductName(xilinxDevice),
+				getDeviceState(xilinxDevice, exclusions))
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "DeviceIndex\tSerialNum\tDeviceBDF\tUserPF\t\t\tMgmtPF\t\t\tShellVersion\t\t\t\tProduct\t\tState\t\tDriver\t\tNUMANode\tSR-IOV\t\t\tTopology\n")
 	for _, xilinxDevice := range xilinxDevices {
-		fmt.Fprintf(os.Stderr, "%-16s%-16s%-16s%-24s%-24s%s\n",
+		numaNode := "-"
+		if node, _, err := getDeviceNUMA(xilinxDevice.DBDF); err == nil && node >= 0 {
+			numaNode = strconv.Itoa(node)
+		}
+		topology := "-"
+		if t, err := getPCITopology(xilinxDevice.DBDF); err == nil {
+			topology = t.String()
+		}
+		sriov := "-"
+		if xilinxDevice.physFn != "" {
+			sriov = "vf of " + xilinxDevice.physFn
+		} else if num := getNumVirtFns(xilinxDevice.DBDF) + getNumVirtFns(xilinxDevice.Pair.MgmtDBDF); num > 0 {
+			sriov = strconv.Itoa(num) + " vfs"
+		}
+		fmt.Fprintf(os.Stderr, "%-16s%-16s%-16s%-24s%-24s%-40s%-16s%-16s%-16s%-16s%-24s%s\n",
 			xilinxDevice.index, xilinxDevice.SN, xilinxDevice.DBDF,
-			xilinxDevice.Pair.User, xilinxDevice.Pair.Mgmt, xilinxDevice.shellVer)
+			xilinxDevice.Pair.User, xilinxDevice.Pair.Mgmt, xilinxDevice.shellVer, getProductName(xilinxDevice),
+			getDeviceState(xilinxDevice, exclusions), xilinxDevice.driver, numaNode, sriov, topology)
 	}
 }
 
@@ -120,16 +226,26 @@ func printCards() {
 		fmt.Fprintf(os.Stderr, err.Error())
 	}
 
-	fmt.Fprintf(os.Stderr, "CardIndex\tSerialNum\tDeviceBDF\tUserPF\t\t\tMgmtPF\t\t\tShellVersion\n")
+	fmt.Fprintf(os.Stderr, "CardIndex\tSerialNum\tDeviceBDF\tUserPF\t\t\tMgmtPF\t\t\tShellVersion\t\t\t\tNote\n")
 	for _, xilinxCard := range xilinxCards {
 		for _, xilinxDevice := range xilinxCard.devices {
-			fmt.Fprintf(os.Stderr, "%-16d%-16s%-16s%-24s%-24s%s\n",
+			fmt.Fprintf(os.Stderr, "%-16d%-16s%-16s%-24s%-24s%-40s%s\n",
 				xilinxCard.index, xilinxCard.devices[0].SN, xilinxDevice.DBDF,
-				xilinxDevice.Pair.User, xilinxDevice.Pair.Mgmt, xilinxCard.devices[0].shellVer)
+				xilinxDevice.Pair.User, xilinxDevice.Pair.Mgmt, xilinxCard.devices[0].shellVer, xilinxCard.note)
 		}
 	}
 }
 
+// save a snapshot of xilinx devices on host into file
+func runSnapshot(file string) {
+	err := saveSnapshot(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Snapshot saved to %s\n", file)
+}
+
 // Instantiate a runtime object and run the command
 func run(argv []string, cfg *config) (err error) {
 	r, err := newRuntime(argv, cfg)
@@ -140,6 +256,37 @@ func run(argv []string, cfg *config) (err error) {
 	return r.Exec(argv)
 }
 
+// Return a list of strings from config, skipping values of other types
+func getConfigStrings(tree *toml.Tree, key string, defaultValue []string) []string {
+	if !tree.Has(key) {
+		return defaultValue
+	}
+	values := []string{}
+	if array, ok := tree.Get(key).([]interface{}); ok {
+		for _, value := range array {
+			if value, ok := value.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Return seconds of timeouts by the keys of a config section
+func getConfigTimeouts(tree *toml.Tree, key string) map[string]int64 {
+	timeouts := make(map[string]int64)
+	section, ok := tree.Get(key).(*toml.Tree)
+	if !ok {
+		return timeouts
+	}
+	for _, name := range section.Keys() {
+		if timeout, ok := section.Get(name).(int64); ok {
+			timeouts[name] = timeout
+		}
+	}
+	return timeouts
+}
+
 // Read config values from a toml file or set via environment
 func getConfig() (*config, error) {
 	cfg := &config{}
@@ -163,6 +310,34 @@ func getConfig() (*config, error) {
 	cfg.debugFilePath = toml.GetDefault(debugFilePathKey, "/dev/null").(string)
 	cfg.deviceExclusive = toml.GetDefault(deviceExclusiveKey, true).(bool)
 	cfg.exclusionFilePath = toml.GetDefault(exclusionFilePathKey, "/var/tmp/xilinx-device-exclusion.json").(string)
+	cfg.xrtHostPath = toml.GetDefault(xrtHostPathKey, "/opt/xilinx/xrt").(string)
+	cfg.xrtICDPath = toml.GetDefault(xrtICDPathKey, "/etc/OpenCL/vendors/xilinx.icd").(string)
+	cfg.xrtVersionCheck = toml.GetDefault(xrtVersionCheckKey, "warn").(string)
+	cfg.xclbinCheck = toml.GetDefault(xclbinCheckKey, "warn").(string)
+	cfg.xclbinProgram = toml.GetDefault(xclbinProgramKey, false).(bool)
+	cfg.xclbinLoader = toml.GetDefault(xclbinLoaderKey, "xbutil program --device {bdf} --user {xclbin}").(string)
+	cfg.xclbinLoadTimeout = toml.GetDefault(xclbinLoadTimeoutKey, int64(300)).(int64)
+	cfg.deviceReset = toml.GetDefault(deviceResetKey, false).(bool)
+	cfg.deviceResetCommand = toml.GetDefault(deviceResetCommandKey, "xbutil reset --device {bdf} --force").(string)
+	cfg.deviceResetTimeout = toml.GetDefault(deviceResetTimeoutKey, int64(300)).(int64)
+	cfg.deviceValidation = toml.GetDefault(deviceValidationKey, false).(bool)
+	cfg.deviceValidationCommand = toml.GetDefault(deviceValidationCommandKey, "").(string)
+	cfg.deviceValidationTimeout = toml.GetDefault(deviceValidationTimeoutKey, int64(60)).(int64)
+	cfg.deviceNodesCreate = toml.GetDefault(deviceNodesCreateKey, false).(bool)
+	cfg.deviceNodesUID = toml.GetDefault(deviceNodesUIDKey, int64(0)).(int64)
+	cfg.deviceNodesGID = toml.GetDefault(deviceNodesGIDKey, int64(0)).(int64)
+	cfg.deviceNodesMode = toml.GetDefault(deviceNodesModeKey, "0666").(string)
+	cfg.numaPin = toml.GetDefault(numaPinKey, false).(bool)
+	cfg.deviceAlias = toml.GetDefault(deviceAliasKey, false).(bool)
+	cfg.emulationPlatform = toml.GetDefault(emulationPlatformKey, "").(string)
+	cfg.emulationMounts = getConfigStrings(toml, emulationMountsKey, []string{})
+	cfg.zoclMounts = getConfigStrings(toml, zoclMountsKey, []string{"/lib/firmware/xilinx"})
+	cfg.catalog = getCatalog(toml)
+	cfg.readinessTimeouts = getConfigTimeouts(toml, readinessKey)
+	cfg.vfio = toml.GetDefault(vfioKey, false).(bool)
+	cfg.vfioRebind = toml.GetDefault(vfioRebindKey, false).(bool)
+	cfg.inventoryCache = toml.GetDefault(inventoryCacheKey, "").(string)
+	cfg.hostRoot = toml.GetDefault(hostRootKey, "").(string)
 
 	return cfg, nil
 }
@@ -180,6 +355,9 @@ func main() {
 	}
 
 	logger.Printf("Running %v", os.Args)
+	catalog = cfg.catalog
+	inventory.cachePath = cfg.inventoryCache
+	hostRoot = getHostRoot(cfg.hostRoot, os.Getenv(envXLNXHostRoot))
 
 	getopt.Getopt(nil)
 	args := getopt.Args()
@@ -200,9 +378,14 @@ func main() {
 		case "h":
 			flag.Usage()
 		case "lsdevice":
-			printDevices()
+			printDevices(cfg, "")
 		case "lscard":
 			printCards()
+		case "snapshot":
+			runSnapshot(defaultSnapshotFile)
+		case "replay":
+			fmt.Fprintf(os.Stderr, "Usage: xilinx-container-runtime replay <file> [bundle]\n")
+			os.Exit(1)
 		default:
 			err := run(os.Args, cfg)
 			if err != nil {
@@ -213,6 +396,46 @@ func main() {
 		}
 	default:
 		// More than one command found
+		if args[0] == "lsdevice" {
+			printDevices(cfg, getOutputFormat(args[1:]))
+			return
+		}
+		if args[0] == "snapshot" {
+			if argn > 2 {
+				fmt.Fprintf(os.Stderr, "Usage: xilinx-container-runtime snapshot [file]\n")
+				os.Exit(1)
+			}
+			runSnapshot(args[1])
+			return
+		}
+		if args[0] == "replay" {
+			if argn > 3 {
+				fmt.Fprintf(os.Stderr, "Usage: xilinx-container-runtime replay <file> [bundle]\n")
+				os.Exit(1)
+			}
+			bundle := ""
+			if argn > 2 {
+				bundle = args[2]
+			}
+			err := replaySnapshot(cfg, args[1], bundle)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if args[0] == "xclbin" {
+			if args[1] != "inspect" || argn < 3 {
+				fmt.Fprintf(os.Stderr, "Usage: xilinx-container-runtime xclbin inspect <file>...\n")
+				os.Exit(1)
+			}
+			err := printXclbins(args[2:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
 		err := run(os.Args, cfg)
 		if err != nil {
 			logger.Errorf("Error running %v: %v", os.Args, err)
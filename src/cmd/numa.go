@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	envXLNXNUMAPin   = "XILINX_NUMA_PIN"
+	NUMANodeFile     = "numa_node"
+	LocalCPUListFile = "local_cpulist"
+)
+
+// Parse a Linux cpu list, like '0-7,16-23', into sorted integers
+func parseCPUList(list string) ([]int, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list '%s'", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list '%s'", list)
+			}
+		}
+		for i := first; i <= last; i++ {
+			set[i] = true
+		}
+	}
+
+	ret := []int{}
+	for i := range set {
+		ret = append(ret, i)
+	}
+	return sortUnique(ret), nil
+}
+
+// Return sorted integers without duplicates
+func sortUnique(ids []int) []int {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	ret := []int{}
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// Format sorted integers into a Linux cpu list, like '0-7,16-23'
+func formatCPUList(ids []int) string {
+	parts := []string{}
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(ids[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", ids[i], ids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Return integers in both lists
+func intersectCPUList(a []int, b []int) []int {
+	set := make(map[int]bool)
+	for _, i := range b {
+		set[i] = true
+	}
+	ret := []int{}
+	for _, i := range a {
+		if set[i] {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// Return the NUMA node and local cpus of a device, NUMA node is -1 if unknown
+func getDeviceNUMA(DBDF string) (int, []int, error) {
+	content, err := getFileContent(hostPath(path.Join(SysfsDevices, DBDF, NUMANodeFile)))
+	if err != nil {
+		return -1, nil, err
+	}
+	node, err := strconv.Atoi(content)
+	if err != nil {
+		return -1, nil, fmt.Errorf("invalid NUMA node '%s' of device %s", content, DBDF)
+	}
+
+	content, err = getFileContent(hostPath(path.Join(SysfsDevices, DBDF, LocalCPUListFile)))
+	if err != nil {
+		return node, nil, err
+	}
+	cpus, err := parseCPUList(content)
+	if err != nil {
+		return node, nil, err
+	}
+	return node, cpus, nil
+}
+
+// check if NUMA pinning is enabled for this container
+func (r xilinxContainerRuntime) numaPinEnabled(spec *specs.Spec) bool {
+	numaPinEnv := getSpecEnv(spec, envXLNXNUMAPin)
+	if numaPinEnv != "" {
+		pin, err := strconv.ParseBool(strings.ToLower(numaPinEnv))
+		if err == nil {
+			return pin
+		}
+		r.logger.Printf("error getting NUMA pinning enable status %v", err)
+	}
+	return r.cfg.numaPin
+}
+
+// pin the container to cpus and memory of the NUMA nodes local to assigned devices
+func (r xilinxContainerRuntime) pinNUMA(spec *specs.Spec, devices []xilinxDevice) error {
+	if !r.numaPinEnabled(spec) {
+		return nil
+	}
+
+	nodes := []int{}
+	cpus := []int{}
+	for _, device := range devices {
+		if isPlatformDevice(device) {
+			r.logger.Infof("No NUMA information for platform device %s, skipping NUMA pinning", device.DBDF)
+			return nil
+		}
+		node, localCPUs, err := getDeviceNUMA(device.DBDF)
+		if err != nil {
+			return fmt.Errorf("error getting NUMA information of device %s: %v", device.DBDF, err)
+		}
+		if node < 0 {
+			r.logger.Infof("No NUMA information for device %s, skipping NUMA pinning", device.DBDF)
+			return nil
+		}
+		nodes = append(nodes, node)
+		cpus = append(cpus, localCPUs...)
+	}
+	nodes = sortUnique(nodes)
+	cpus = sortUnique(cpus)
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	if spec.Linux.Resources.CPU == nil {
+		spec.Linux.Resources.CPU = &specs.LinuxCPU{}
+	}
+	cpu := spec.Linux.Resources.CPU
+
+	// Respect the cpuset set by user, only narrowing it down
+	if cpu.Cpus != "" {
+		userCPUs, err := parseCPUList(cpu.Cpus)
+		if err != nil {
+			return err
+		}
+		cpus = intersectCPUList(userCPUs, cpus)
+	}
+	if cpu.Mems != "" {
+		userNodes, err := parseCPUList(cpu.Mems)
+		if err != nil {
+			return err
+		}
+		nodes = intersectCPUList(userNodes, nodes)
+	}
+	if len(cpus) == 0 || len(nodes) == 0 {
+		r.logger.Warnf("cpuset of the container does not overlap NUMA nodes of devices, skipping NUMA pinning")
+		return nil
+	}
+
+	cpu.Cpus = formatCPUList(cpus)
+	cpu.Mems = formatCPUList(nodes)
+	r.logger.Infof("Pinning container to cpus %s and memory nodes %s", cpu.Cpus, cpu.Mems)
+	return nil
+}
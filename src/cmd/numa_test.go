@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUList(t *testing.T) {
+	testCases := []struct {
+		list       string
+		formatted  string
+		shouldFail bool
+	}{
+		{
+			list:      "0-7,16-23\n",
+			formatted: "0-7,16-23",
+		},
+		{
+			list:      "3,1,2,5",
+			formatted: "1-3,5",
+		},
+		{
+			list:      "",
+			formatted: "",
+		},
+		{
+			list:       "7-0",
+			shouldFail: true,
+		},
+		{
+			list:       "a",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		ids, err := parseCPUList(tc.list)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, tc.formatted, formatCPUList(ids), "%d: %v", i, tc)
+	}
+}
+
+func TestIntersectCPUList(t *testing.T) {
+	a, _ := parseCPUList("0-15")
+	b, _ := parseCPUList("8-23")
+	require.Equal(t, "8-15", formatCPUList(intersectCPUList(a, b)))
+	require.Equal(t, []int{0, 1, 3}, sortUnique([]int{3, 1, 0, 3, 1}))
+}
+
+func TestPinNUMA(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{numaPin: true},
+	}
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.1/numa_node":     "0",
+		"sys/bus/pci/devices/0000:3b:00.1/local_cpulist": "0-7,16-23",
+		"sys/bus/pci/devices/0000:5e:00.1/numa_node":     "0",
+		"sys/bus/pci/devices/0000:5e:00.1/local_cpulist": "0-7,16-23",
+		"sys/bus/pci/devices/0000:d8:00.1/numa_node":     "1",
+		"sys/bus/pci/devices/0000:d8:00.1/local_cpulist": "8-15,24-31",
+		"sys/bus/pci/devices/0000:af:00.1/numa_node":     "-1",
+		"sys/bus/pci/devices/0000:af:00.1/local_cpulist": "0-31",
+	})
+
+	testCases := []struct {
+		env      []string
+		DBDFs    []string
+		cpu      *specs.LinuxCPU
+		expected *specs.LinuxCPU
+	}{
+		{
+			DBDFs:    []string{"0000:3b:00.1", "0000:5e:00.1"},
+			expected: &specs.LinuxCPU{Cpus: "0-7,16-23", Mems: "0"},
+		},
+		{
+			DBDFs:    []string{"0000:3b:00.1", "0000:d8:00.1"},
+			expected: &specs.LinuxCPU{Cpus: "0-31", Mems: "0-1"},
+		},
+		{
+			// the cpuset of the user is only narrowed down
+			DBDFs:    []string{"0000:3b:00.1"},
+			cpu:      &specs.LinuxCPU{Cpus: "4-11", Mems: "0-1"},
+			expected: &specs.LinuxCPU{Cpus: "4-7", Mems: "0"},
+		},
+		{
+			// the cpuset of the user is kept if it does not overlap
+			DBDFs:    []string{"0000:d8:00.1"},
+			cpu:      &specs.LinuxCPU{Cpus: "0-3"},
+			expected: &specs.LinuxCPU{Cpus: "0-3"},
+		},
+		{
+			// devices without NUMA information are not pinned
+			DBDFs: []string{"0000:3b:00.1", "0000:af:00.1"},
+		},
+		{
+			env:   []string{"XILINX_NUMA_PIN=false"},
+			DBDFs: []string{"0000:3b:00.1"},
+		},
+	}
+
+	for i, tc := range testCases {
+		devices := []xilinxDevice{}
+		for _, DBDF := range tc.DBDFs {
+			devices = append(devices, xilinxDevice{DBDF: DBDF, Pair: &xilinxPair{}})
+		}
+		spec := &specs.Spec{
+			Process: &specs.Process{Env: tc.env},
+			Linux:   &specs.Linux{Resources: &specs.LinuxResources{CPU: tc.cpu}},
+		}
+		require.NoErrorf(t, shim.pinNUMA(spec, devices), "%d: %v", i, tc)
+		require.Equalf(t, tc.expected, spec.Linux.Resources.CPU, "%d: %v", i, tc)
+	}
+}
@@ -41,7 +41,12 @@ func newRuntime(argv []string, cfg *config) (oci.Runtime, error) {
 		return nil, fmt.Errorf("error constructing runc runtime: %v", err)
 	}
 
-	xlnxcr, err := newXilinxContainerRuntimeWithLogger(logger.Logger, cfg, runc, ociSpec)
+	bundleDir, err := getBundleDir(argv)
+	if err != nil {
+		return nil, fmt.Errorf("error getting bundle directory: %v", err)
+	}
+
+	xlnxcr, err := newXilinxContainerRuntimeWithLogger(logger.Logger, cfg, runc, ociSpec, bundleDir)
 	if err != nil {
 		return nil, fmt.Errorf("error constructing Xilinx Container Runtime: %v", err)
 	}
@@ -111,6 +116,19 @@ func getBundlePath(argv []string) (string, error) {
 	return bundlePath, nil
 }
 
+// getBundleDir returns the bundle directory for the provided arguments or the
+// current working directory if not specified.
+func getBundleDir(argv []string) (string, error) {
+	bundlePath, err := getBundlePath(argv)
+	if err != nil {
+		return "", fmt.Errorf("error parsing command line arguments: %v", err)
+	}
+	if bundlePath != "" {
+		return bundlePath, nil
+	}
+	return os.Getwd()
+}
+
 // findRunc locates runc in the path, returning the full path to the
 // binary or an error.
 func findRunc() (string, error) {
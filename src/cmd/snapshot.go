@@ -0,0 +1,520 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xilinx/xilinx-container-runtime/src/pkg/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	defaultSnapshotFile = "xilinx-sysfs-snapshot.tar.gz"
+)
+
+// Attributes of Xilinx PCI functions read by discovery, validation, NUMA pinning and SR-IOV
+var snapshotFunctionFiles = []string{
+	VendorFile,
+	DeviceFile,
+	InstanceFile,
+	MgmtFile,
+	UserFile,
+	InterfaceUUIDFile,
+	NUMANodeFile,
+	LocalCPUListFile,
+	SriovNumVFsFile,
+	AMILogicUUIDFile,
+	AMIBoardSerialFile,
+	ReadyFile,
+}
+
+// Sub folders of Xilinx PCI functions by name prefix, with the attributes read in them
+var snapshotFunctionDirs = []struct {
+	prefix string
+	files  []string
+}{
+	{ROMSTR, []string{DSAverFile, DSAtsFile}},
+	{SNSTR, []string{SNFile}},
+	{QDMASTR, []string{}},
+	{UserPFKeyword, []string{}},
+	{AMIClass, []string{}},
+}
+
+// Device nodes recorded with their device numbers
+var snapshotNodePatterns = []string{
+	path.Join(UserPrefix, "*"),
+	MgmtPrefix + "*",
+	path.Join(QdmaPrefix, "*"),
+	path.Join(AMIPrefix, AMIClass+"*"),
+	path.Join(VFIOPrefix, "*"),
+}
+
+/*
+Device numbers of nodes in a replayed snapshot by their path under the host
+root. Nodes are extracted as empty files, since creating device nodes needs
+privileges.
+*/
+var snapshotNodes = map[string][2]int64{}
+
+// snapshotWriter writes files under the host root into a tarball, with their host-absolute paths
+type snapshotWriter struct {
+	tw      *tar.Writer
+	written map[string]bool
+}
+
+// Return the name of a host-absolute path in the tarball
+func snapshotName(p string) string {
+	return strings.TrimPrefix(path.Clean(p), "/")
+}
+
+// add a folder and its parents
+func (w *snapshotWriter) addDir(p string) error {
+	p = path.Clean(p)
+	if p == "/" || w.written[p] {
+		return nil
+	}
+	if err := w.addDir(path.Dir(p)); err != nil {
+		return err
+	}
+	w.written[p] = true
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     snapshotName(p) + "/",
+		Mode:     0755,
+		ModTime:  time.Now(),
+	})
+}
+
+// add a file with its content, skipping files not existing or not readable like write-only attributes
+func (w *snapshotWriter) addFile(p string) error {
+	if w.written[p] {
+		return nil
+	}
+	content, err := ioutil.ReadFile(hostPath(p))
+	if err != nil {
+		return nil
+	}
+	if err = w.addDir(path.Dir(p)); err != nil {
+		return err
+	}
+	w.written[p] = true
+	err = w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     snapshotName(p),
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(content)
+	return err
+}
+
+// add a symbolic link, returning the host-absolute path it points to, empty if it is not a link
+func (w *snapshotWriter) addLink(p string) (string, error) {
+	link, err := os.Readlink(hostPath(p))
+	if err != nil {
+		return "", nil
+	}
+	target := link
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(p), link)
+	}
+	if w.written[p] {
+		return target, nil
+	}
+	if err = w.addDir(path.Dir(p)); err != nil {
+		return "", err
+	}
+	w.written[p] = true
+	return target, w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     snapshotName(p),
+		Linkname: link,
+		Mode:     0777,
+		ModTime:  time.Now(),
+	})
+}
+
+// add a character device node with its device numbers
+func (w *snapshotWriter) addNode(p string) error {
+	info, err := os.Lstat(hostPath(p))
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 || w.written[p] {
+		return nil
+	}
+	major, minor, err := getDeviceMajorMinor(hostPath(p))
+	if err != nil {
+		return nil
+	}
+	if err = w.addDir(path.Dir(p)); err != nil {
+		return err
+	}
+	w.written[p] = true
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeChar,
+		Name:     snapshotName(p),
+		Mode:     int64(info.Mode().Perm()),
+		Devmajor: major,
+		Devminor: minor,
+		ModTime:  time.Now(),
+	})
+}
+
+// add the listed attributes of a sysfs folder
+func (w *snapshotWriter) addFiles(dir string, names []string) error {
+	for _, name := range names {
+		if err := w.addFile(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add the sysfs folder of a Xilinx device, following the link to its real location in the hierarchy
+func (w *snapshotWriter) addDevice(link string) error {
+	dir, err := w.addLink(link)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = link
+	}
+	if err = w.addDir(dir); err != nil {
+		return err
+	}
+	if err = w.addFiles(dir, snapshotFunctionFiles); err != nil {
+		return err
+	}
+
+	for _, name := range []string{DriverLink, PhysFnLink} {
+		target, err := w.addLink(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		// Links are checked by stat, so their targets must exist
+		if target != "" {
+			if err = w.addDir(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := ioutil.ReadDir(hostPath(dir))
+	if err != nil {
+		return nil
+	}
+	for _, file := range files {
+		for _, d := range snapshotFunctionDirs {
+			if !strings.HasPrefix(file.Name(), d.prefix) {
+				continue
+			}
+			sub := path.Join(dir, file.Name())
+			if err = w.addDir(sub); err != nil {
+				return err
+			}
+			if err = w.addFiles(sub, d.files); err != nil {
+				return err
+			}
+			// Character devices under drm or ami, like drm/renderD128/dev
+			children, _ := ioutil.ReadDir(hostPath(sub))
+			for _, child := range children {
+				if err = w.addFile(path.Join(sub, child.Name(), DevFile)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// write metadata of Xilinx devices on host read by discovery into a gzipped tarball
+func writeSnapshot(out io.Writer) error {
+	gw := gzip.NewWriter(out)
+	w := &snapshotWriter{tw: tar.NewWriter(gw), written: make(map[string]bool)}
+
+	// Functions of other vendors are recorded with their vendor only
+	functions, _ := ioutil.ReadDir(hostPath(SysfsDevices))
+	for _, function := range functions {
+		link := path.Join(SysfsDevices, function.Name())
+		vendorID, err := getFileContent(hostPath(path.Join(link, VendorFile)))
+		if err == nil && isXilinxVendor(vendorID) {
+			err = w.addDevice(link)
+		} else {
+			err = w.addFile(path.Join(link, VendorFile))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	slots, _ := ioutil.ReadDir(hostPath(SysfsSlots))
+	for _, slot := range slots {
+		if err := w.addFile(path.Join(SysfsSlots, slot.Name(), SlotAddressFile)); err != nil {
+			return err
+		}
+	}
+
+	platformDevices, _ := ioutil.ReadDir(hostPath(SysfsZoclDriver))
+	for _, platformDevice := range platformDevices {
+		name := platformDevice.Name()
+		if !fileExist(hostPath(path.Join(SysfsZoclDriver, name, UserPFKeyword))) {
+			continue
+		}
+		if _, err := w.addLink(path.Join(SysfsZoclDriver, name)); err != nil {
+			return err
+		}
+		if err := w.addDevice(path.Join(SysfsPlatformDevices, name)); err != nil {
+			return err
+		}
+	}
+
+	files := []string{DeviceTreeModel}
+	for _, module := range xrtDriverModules {
+		files = append(files, path.Join(SysfsModules, module, ModuleVersionFile))
+	}
+	for _, file := range files {
+		if err := w.addFile(file); err != nil {
+			return err
+		}
+	}
+
+	for _, pattern := range snapshotNodePatterns {
+		nodes, _ := filepath.Glob(hostPath(pattern))
+		for _, node := range nodes {
+			if err := w.addNode(path.Join(path.Dir(pattern), path.Base(node))); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// save a snapshot of Xilinx devices on host into file
+func saveSnapshot(file string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot file: %v", err)
+	}
+	defer out.Close()
+
+	err = writeSnapshot(out)
+	if err != nil {
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+	return out.Close()
+}
+
+/*
+Extract a snapshot into a folder used as host root, returning the device
+numbers of recorded device nodes by their extracted paths.
+*/
+func extractSnapshot(in io.Reader, dir string) (map[string][2]int64, error) {
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string][2]int64)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		target := path.Join(dir, path.Clean("/"+header.Name))
+		if !insideSnapshot(dir, target, header) {
+			return nil, fmt.Errorf("invalid entry %s in snapshot", header.Name)
+		}
+		if err = os.MkdirAll(path.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			var content []byte
+			if content, err = ioutil.ReadAll(tr); err == nil {
+				err = ioutil.WriteFile(target, content, 0644)
+			}
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeChar:
+			nodes[target] = [2]int64{header.Devmajor, header.Devminor}
+			err = ioutil.WriteFile(target, nil, 0644)
+		default:
+			err = fmt.Errorf("unsupported entry %s in snapshot", header.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+check if an entry is extracted inside the folder: there is no symbolic link
+in its parents, it does not replace an existing file, which could be a link,
+and a link does not point outside of the folder.
+*/
+func insideSnapshot(dir string, target string, header *tar.Header) bool {
+	for p := path.Dir(target); strings.HasPrefix(p, dir+"/"); p = path.Dir(p) {
+		if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return false
+		}
+	}
+	if info, err := os.Lstat(target); err == nil && (header.Typeflag != tar.TypeDir || !info.IsDir()) {
+		return false
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return !path.IsAbs(header.Linkname) && strings.HasPrefix(path.Join(path.Dir(target), header.Linkname), dir+"/")
+	}
+	return true
+}
+
+// replayRuntime stands for the underlying runtime while replaying a snapshot, nothing is forwarded
+type replayRuntime struct{}
+
+func (r replayRuntime) Exec(args []string) error {
+	return nil
+}
+
+/*
+Run discovery against a snapshot, listing devices and cards, and if a bundle
+is given, create a container from its OCI spec and print the modified spec.
+Side effects on host, like programming xclbins, resetting or rebinding devices
+and creating device nodes, are disabled, and the device exclusion file is kept
+in a temporary folder.
+*/
+func replaySnapshot(cfg *config, file string, bundle string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("error opening snapshot file: %v", err)
+	}
+	defer in.Close()
+
+	dir, err := ioutil.TempDir("", "xilinx-snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	root := path.Join(dir, "root")
+	snapshotNodes, err = extractSnapshot(in, root)
+	if err != nil {
+		return fmt.Errorf("error extracting snapshot: %v", err)
+	}
+	hostRoot = root
+	inventory = &deviceInventory{backends: discoveryBackends}
+
+	replayCfg := *cfg
+	replayCfg.exclusionFilePath = path.Join(dir, "xilinx-device-exclusion.json")
+	replayCfg.xclbinProgram = false
+	replayCfg.deviceReset = false
+	replayCfg.deviceNodesCreate = false
+	replayCfg.deviceValidationCommand = ""
+	replayCfg.vfioRebind = false
+
+	printDevices(&replayCfg, "wide")
+	fmt.Fprintf(os.Stderr, "\n")
+	printCards()
+	if bundle == "" {
+		return nil
+	}
+
+	// Files written next to the spec, like device metadata, are kept in a temporary bundle
+	replayBundle := path.Join(dir, "bundle")
+	specFile := path.Join(replayBundle, ociSpecFileName)
+	content, err := ioutil.ReadFile(path.Join(bundle, ociSpecFileName))
+	if err != nil {
+		return fmt.Errorf("error reading OCI specification: %v", err)
+	}
+	var spec specs.Spec
+	if err = json.Unmarshal(content, &spec); err != nil {
+		return fmt.Errorf("error reading OCI specification: %v", err)
+	}
+	if err = os.MkdirAll(replayBundle, 0755); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(specFile, content, 0644); err != nil {
+		return err
+	}
+	// The image is still checked in the original bundle, like for the XRT version
+	if spec.Root != nil && !path.IsAbs(spec.Root.Path) {
+		rootfs, err := filepath.Abs(path.Join(bundle, spec.Root.Path))
+		if err != nil {
+			return err
+		}
+		link := path.Join(replayBundle, spec.Root.Path)
+		if err = os.MkdirAll(path.Dir(link), 0755); err != nil {
+			return err
+		}
+		if err = os.Symlink(rootfs, link); err != nil {
+			return err
+		}
+	}
+
+	r, err := newXilinxContainerRuntimeWithLogger(logger.Logger, &replayCfg, replayRuntime{}, oci.NewSpecFromFile(specFile), replayBundle)
+	if err != nil {
+		return err
+	}
+	if err = r.Exec([]string{os.Args[0], "create", "--bundle", replayBundle, "replay"}); err != nil {
+		return err
+	}
+
+	content, err = ioutil.ReadFile(specFile)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(content, &spec); err != nil {
+		return err
+	}
+	content, err = json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+// Return device numbers of a node recorded in a replayed snapshot
+func getSnapshotMajorMinor(devPath string) (int64, int64, bool) {
+	node, ok := snapshotNodes[devPath]
+	if !ok {
+		return 0, 0, false
+	}
+	return node[0], node[1], true
+}
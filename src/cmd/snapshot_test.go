@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// write a gzipped tarball with given entries
+func writeTarball(t *testing.T, headers []*tar.Header) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, header := range headers {
+		require.NoError(t, tw.WriteHeader(header))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	function := "sys/devices/pci0000:3a/0000:3a:00.0/0000:3b:00.1"
+	mgmt := "sys/devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0"
+	writeSysfsFixture(t, root, map[string]string{
+		mgmt + "/vendor":                             "0x10ee",
+		mgmt + "/device":                             "0x503c",
+		mgmt + "/mgmt_pf":                            "1",
+		mgmt + "/instance":                           "15104",
+		function + "/vendor":                         "0x10ee",
+		function + "/device":                         "0x503d",
+		function + "/user_pf":                        "1",
+		function + "/numa_node":                      "0",
+		function + "/local_cpulist":                  "0-3",
+		function + "/rom.u.1/VBNV":                   "xilinx_u30_gen3x4_base_1",
+		function + "/rom.u.1/timestamp":              "0x0000000000000001",
+		function + "/xmc.u.2/serial_num":             "XFL1YV0M20E0",
+		function + "/drm/renderD128/dev":             "226:128",
+		"sys/devices/pci0000:00/0000:00:1f.0/vendor": "0x8086",
+		"sys/bus/pci/drivers/xocl":                   "",
+		"sys/bus/pci/slots/1/address":                "0000:3b:00",
+	})
+	for _, dir := range []string{mgmt, function, "sys/devices/pci0000:00/0000:00:1f.0"} {
+		link := path.Join(root, SysfsDevices, path.Base(dir))
+		require.NoError(t, os.MkdirAll(path.Dir(link), 0755))
+		require.NoError(t, os.Symlink(path.Join("../../..", strings.TrimPrefix(dir, "sys/")), link))
+	}
+	require.NoError(t, os.Symlink("../../../../bus/pci/drivers/xocl", path.Join(root, function, DriverLink)))
+
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = root
+	recorded, failed := discoverXilinxDevices([]discoveryBackend{xoclBackend{}})
+	require.Empty(t, failed)
+	require.Equal(t, 1, len(recorded))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeSnapshot(buf))
+
+	replayed := path.Join(t.TempDir(), "root")
+	_, err := extractSnapshot(buf, replayed)
+	require.NoError(t, err)
+	// Functions of other vendors are recorded with their vendor only
+	require.False(t, fileExist(path.Join(replayed, "sys/devices/pci0000:00/0000:00:1f.0/device")))
+
+	hostRoot = replayed
+	devices, failed := discoverXilinxDevices([]discoveryBackend{xoclBackend{}})
+	require.Empty(t, failed)
+	require.Equal(t, recorded, devices)
+
+	device := devices[0]
+	// Device nodes are not in the fixture, but sysfs is
+	problems := validateXilinxDevice(device)
+	require.NotContains(t, problems, "no driver bound")
+	require.NotContains(t, problems, "no shell information found")
+	topology, err := getPCITopology(device.DBDF)
+	require.NoError(t, err)
+	require.Equal(t, "pci0000:3a>0000:3a:00.0", topology.String())
+	node, cpus, err := getDeviceNUMA(device.DBDF)
+	require.NoError(t, err)
+	require.Equal(t, 0, node)
+	require.Equal(t, []int{0, 1, 2, 3}, cpus)
+	require.Equal(t, map[string]string{"0000:3b:00": "1"}, getPCISlots())
+}
+
+func TestExtractSnapshot(t *testing.T) {
+	testCases := []struct {
+		headers    []*tar.Header
+		nodes      map[string][2]int64
+		shouldFail bool
+	}{
+		{
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeDir, Name: "dev/dri/", Mode: 0755},
+				{Typeflag: tar.TypeChar, Name: "dev/dri/renderD128", Mode: 0666, Devmajor: 226, Devminor: 128},
+			},
+			nodes: map[string][2]int64{"/dev/dri/renderD128": {226, 128}},
+		},
+		{
+			// parents are cleaned inside the folder
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeChar, Name: "../../dev/xclmgmt15104", Mode: 0666, Devmajor: 239, Devminor: 0},
+			},
+			nodes: map[string][2]int64{"/dev/xclmgmt15104": {239, 0}},
+		},
+		{
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "sys/bus", Linkname: "/etc", Mode: 0777},
+			},
+			shouldFail: true,
+		},
+		{
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "sys/bus", Linkname: "../../..", Mode: 0777},
+			},
+			shouldFail: true,
+		},
+		{
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "sys/bus", Linkname: "../dev", Mode: 0777},
+				{Typeflag: tar.TypeReg, Name: "sys/bus/passwd", Mode: 0644},
+			},
+			shouldFail: true,
+		},
+		{
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "sys/vendor", Linkname: "../dev/vendor", Mode: 0777},
+				{Typeflag: tar.TypeReg, Name: "sys/vendor", Mode: 0644},
+			},
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		dir := t.TempDir()
+		nodes, err := extractSnapshot(writeTarball(t, tc.headers), dir)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, len(tc.nodes), len(nodes), "%d: %v", i, tc)
+		for node, numbers := range tc.nodes {
+			require.Equalf(t, numbers, nodes[path.Join(dir, node)], "%d: %v", i, tc)
+			require.Truef(t, fileExist(path.Join(dir, node)), "%d: %v", i, tc)
+		}
+	}
+}
+
+func TestReplaySnapshotNestedRoot(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	defer func(nodes map[string][2]int64) { snapshotNodes = nodes }(snapshotNodes)
+
+	root := t.TempDir()
+	writeSysfsFixture(t, root, map[string]string{
+		"sys/bus/pci/devices": "",
+	})
+	hostRoot = root
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeSnapshot(buf))
+	file := path.Join(t.TempDir(), "snapshot.tar.gz")
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0644))
+
+	// The rootfs is nested below the bundle
+	bundle := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(bundle, "a/rootfs"), 0755))
+	spec := `{"ociVersion": "1.0.2", "process": {"env": []}, "root": {"path": "a/rootfs"}}`
+	require.NoError(t, os.WriteFile(path.Join(bundle, ociSpecFileName), []byte(spec), 0644))
+
+	require.NoError(t, replaySnapshot(&config{xclbinCheck: xrtCheckIgnore}, file, bundle))
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	PhysFnLink            = "physfn"
+	SriovNumVFsFile       = "sriov_numvfs"
+	virtFnSelector        = "/vf"
+	envXLNXDeviceFunction = "XILINX_DEVICE_FUNCTION"
+	deviceFunctionPF      = "pf"
+	deviceFunctionVF      = "vf"
+	deviceFunctionAll     = "all"
+)
+
+// check if the PCI function is a SR-IOV virtual function
+func isVirtFn(pciID string) bool {
+	return fileExist(hostPath(path.Join(SysfsDevices, pciID, PhysFnLink)))
+}
+
+// Return the physical function a SR-IOV virtual function belongs to
+func getPhysFn(pciID string) (string, error) {
+	link, err := os.Readlink(hostPath(path.Join(SysfsDevices, pciID, PhysFnLink)))
+	if err != nil {
+		return "", fmt.Errorf("Can't get physical function of %s", pciID)
+	}
+	return path.Base(link), nil
+}
+
+// Return the number of enabled virtual functions of a physical function, 0 if SR-IOV is not supported
+func getNumVirtFns(DBDF string) int {
+	if DBDF == "" {
+		return 0
+	}
+	content, err := getFileContent(hostPath(path.Join(SysfsDevices, DBDF, SriovNumVFsFile)))
+	if err != nil {
+		return 0
+	}
+	num, err := strconv.Atoi(content)
+	if err != nil {
+		return 0
+	}
+	return num
+}
+
+/*
+Return a virtual function as a device of its own, with its own user function
+node. Fields describing the card, like the shell version, are filled in from
+the physical function by adoptVirtFns.
+*/
+func getVirtFnDevice(pciID string) (xilinxDevice, error) {
+	physFn, err := getPhysFn(pciID)
+	if err != nil {
+		return xilinxDevice{}, err
+	}
+	devid, err := getFileContent(hostPath(path.Join(SysfsDevices, pciID, DeviceFile)))
+	if err != nil {
+		return xilinxDevice{}, err
+	}
+
+	// The virtual function has no user function node if it is not bound to xocl, like for vfio-pci
+	pair := &xilinxPair{}
+	if fileExist(hostPath(path.Join(SysfsDevices, pciID, UserPFKeyword))) {
+		userpf, err := getFileNameFromPrefix(hostPath(path.Join(SysfsDevices, pciID, UserPFKeyword)), DRMSTR)
+		if err != nil {
+			return xilinxDevice{}, err
+		}
+		if userpf != "" {
+			pair.User = path.Join(UserPrefix, userpf)
+		}
+	}
+
+	return xilinxDevice{
+		DBDF:     pciID,
+		deviceID: devid,
+		physFn:   physFn,
+		Pair:     pair,
+	}, nil
+}
+
+/*
+Append virtual functions after all physical functions, so that indexes of
+physical functions do not change when virtual functions are enabled. The
+virtual functions take the card details of their physical function, which
+may be either the user or the management function of a device.
+*/
+func adoptVirtFns(devices []xilinxDevice, virtFns []xilinxDevice) []xilinxDevice {
+	physFns := len(devices)
+	for _, virtFn := range virtFns {
+		for i := 0; i < physFns; i++ {
+			parent := devices[i]
+			if virtFn.physFn != parent.DBDF && virtFn.physFn != parent.Pair.MgmtDBDF {
+				continue
+			}
+			virtFn.shellVer = parent.shellVer
+			virtFn.timestamp = parent.timestamp
+			virtFn.SN = parent.SN
+			virtFn.product = parent.product
+			break
+		}
+		devices = append(devices, virtFn)
+	}
+	return devices
+}
+
+// Return the virtual functions of a device, in the order of their BDFs
+func getVirtFns(allDevices []xilinxDevice, device xilinxDevice) []xilinxDevice {
+	virtFns := []xilinxDevice{}
+	for _, virtFn := range allDevices {
+		if virtFn.physFn == "" {
+			continue
+		}
+		if virtFn.physFn == device.DBDF || (device.Pair != nil && virtFn.physFn == device.Pair.MgmtDBDF && device.Pair.MgmtDBDF != "") {
+			virtFns = append(virtFns, virtFn)
+		}
+	}
+	return virtFns
+}
+
+/*
+Select virtual functions by selectors like '0/vf1' or '0000:3b:00.1/vf0',
+which refer to the N-th virtual function of the device matched by the part
+before '/vf'.
+*/
+func selectVirtFns(allDevices []xilinxDevice, selector string) ([]xilinxDevice, error) {
+	parts := strings.SplitN(selector, virtFnSelector, 2)
+	num, err := strconv.Atoi(parts[1])
+	if err != nil || num < 0 {
+		return nil, fmt.Errorf("invalid virtual function selector %s", selector)
+	}
+
+	selected := []xilinxDevice{}
+	for _, device := range allDevices {
+		if device.physFn != "" || !matchXilinxDevice(device, parts[0]) {
+			continue
+		}
+		virtFns := getVirtFns(allDevices, device)
+		if num >= len(virtFns) {
+			return nil, fmt.Errorf("virtual function %d of device %s not existed", num, device.DBDF)
+		}
+		selected = append(selected, virtFns[num])
+	}
+	return selected, nil
+}
+
+// Return the kind of functions to allocate by count, physical functions by default
+func getDeviceFunction(envValue string) (string, error) {
+	switch strings.ToLower(envValue) {
+	case "", deviceFunctionPF:
+		return deviceFunctionPF, nil
+	case deviceFunctionVF:
+		return deviceFunctionVF, nil
+	case deviceFunctionAll:
+		return deviceFunctionAll, nil
+	}
+	return "", fmt.Errorf("only '%s', '%s' or '%s' allowed for env %s", deviceFunctionPF, deviceFunctionVF, deviceFunctionAll, envXLNXDeviceFunction)
+}
+
+// check if the device is the kind of functions to allocate
+func matchDeviceFunction(device xilinxDevice, function string) bool {
+	switch function {
+	case deviceFunctionPF:
+		return device.physFn == ""
+	case deviceFunctionVF:
+		return device.physFn != ""
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Return two physical functions, the second with two virtual functions on its management function
+func getSriovDevices() []xilinxDevice {
+	devices := []xilinxDevice{
+		{
+			index:    "0",
+			DBDF:     "0000:3b:00.1",
+			shellVer: "xilinx_u250_gen3x16_base_4",
+			SN:       "21320733400B",
+			Pair:     &xilinxPair{User: "/dev/dri/renderD128", Mgmt: "/dev/xclmgmt15104", MgmtDBDF: "0000:3b:00.0"},
+		},
+		{
+			index:    "1",
+			DBDF:     "0000:d8:00.1",
+			shellVer: "xilinx_u55c_gen3x16_xdma_base_3",
+			SN:       "XFL1RT5PHT31",
+			Pair:     &xilinxPair{User: "/dev/dri/renderD129", Mgmt: "/dev/xclmgmt55296", MgmtDBDF: "0000:d8:00.0"},
+		},
+	}
+	virtFns := []xilinxDevice{
+		{
+			DBDF:   "0000:d8:00.4",
+			physFn: "0000:d8:00.0",
+			Pair:   &xilinxPair{User: "/dev/dri/renderD130"},
+		},
+		{
+			DBDF:   "0000:d8:00.5",
+			physFn: "0000:d8:00.0",
+			Pair:   &xilinxPair{},
+		},
+	}
+	return adoptVirtFns(devices, virtFns)
+}
+
+func TestAdoptVirtFns(t *testing.T) {
+	devices := getSriovDevices()
+	require.Equal(t, 4, len(devices))
+	require.Equal(t, "XFL1RT5PHT31", devices[2].SN)
+	require.Equal(t, "xilinx_u55c_gen3x16_xdma_base_3", devices[3].shellVer)
+
+	virtFns := getVirtFns(devices, devices[1])
+	require.Equal(t, 2, len(virtFns))
+	require.Equal(t, 0, len(getVirtFns(devices, devices[0])))
+}
+
+func TestSelectXilinxDevices(t *testing.T) {
+	devices := getSriovDevices()
+
+	testCases := []struct {
+		selector   string
+		expected   []string
+		shouldFail bool
+	}{
+		{
+			selector: "0,1",
+			expected: []string{"0000:3b:00.1", "0000:d8:00.1"},
+		},
+		{
+			selector: "1/vf1",
+			expected: []string{"0000:d8:00.5"},
+		},
+		{
+			selector: "0000:d8:00.1/vf0,0000:3b:00.1",
+			expected: []string{"0000:d8:00.4", "0000:3b:00.1"},
+		},
+		{
+			selector: "0000:d8:00.4",
+			expected: []string{"0000:d8:00.4"},
+		},
+		{
+			selector:   "0/vf0",
+			shouldFail: true,
+		},
+		{
+			selector:   "1/vfx",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		selected, err := selectXilinxDevices(devices, tc.selector)
+		if tc.shouldFail {
+			require.NotNilf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		DBDFs := []string{}
+		for _, device := range selected {
+			DBDFs = append(DBDFs, device.DBDF)
+		}
+		require.Equalf(t, tc.expected, DBDFs, "%d: %v", i, tc)
+	}
+}
+
+func TestMatchDeviceFunction(t *testing.T) {
+	devices := getSriovDevices()
+
+	testCases := []struct {
+		env      string
+		expected int
+	}{
+		{
+			env:      "",
+			expected: 2,
+		},
+		{
+			env:      "vf",
+			expected: 2,
+		},
+		{
+			env:      "all",
+			expected: 4,
+		},
+	}
+
+	for i, tc := range testCases {
+		function, err := getDeviceFunction(tc.env)
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		matched := 0
+		for _, device := range devices {
+			if matchDeviceFunction(device, function) {
+				matched++
+			}
+		}
+		require.Equalf(t, tc.expected, matched, "%d: %v", i, tc)
+	}
+
+	_, err := getDeviceFunction("virtual")
+	require.NotNil(t, err)
+}
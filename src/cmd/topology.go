@@ -0,0 +1,351 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	envXLNXDeviceCount = "XILINX_DEVICE_COUNT"
+	SysfsSlots         = "/sys/bus/pci/slots"
+	SlotAddressFile    = "address"
+)
+
+var pciDBDFPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,}:[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+
+// Position of a PCI function in the PCIe hierarchy
+type pciTopology struct {
+	root    string   // PCI root bus, like 'pci0000:00'
+	bridges []string // upstream bridges from the root port down to the function
+}
+
+// Return the topology key of the upstream bridge at given depth, 0 meaning the root bus
+func (t pciTopology) key(depth int) string {
+	return strings.Join(append([]string{t.root}, t.bridges[:depth]...), "/")
+}
+
+// Return the upstream bridges as a readable chain, like '0000:00:01.0>0000:01:00.0'
+func (t pciTopology) String() string {
+	if len(t.bridges) == 0 {
+		return t.root
+	}
+	return t.root + ">" + strings.Join(t.bridges, ">")
+}
+
+// Parse the topology from the resolved sysfs path of a PCI function,
+// like /sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.1
+func parsePCITopology(devicePath string) *pciTopology {
+	topology := &pciTopology{}
+	parts := strings.Split(strings.Trim(devicePath, "/"), "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "pci") {
+			topology.root = part
+			topology.bridges = []string{}
+			continue
+		}
+		// the last element is the function itself
+		if pciDBDFPattern.MatchString(part) && i != len(parts)-1 {
+			topology.bridges = append(topology.bridges, part)
+		}
+	}
+	return topology
+}
+
+// Return the topology of a PCI function from its sysfs parent chain
+func getPCITopology(DBDF string) (*pciTopology, error) {
+	devicePath, err := filepath.EvalSymlinks(hostPath(path.Join(SysfsDevices, DBDF)))
+	if err != nil {
+		return nil, fmt.Errorf("Can't resolve sysfs path of %s", DBDF)
+	}
+	return parsePCITopology(devicePath), nil
+}
+
+/*
+Select n devices preferring the ones sharing the closest common upstream
+bridge, so that peer-to-peer transfers between them stay under the same
+PCIe switch or root port. Devices are picked in the order given.
+*/
+func selectDevicesByTopology(devices []xilinxDevice, topologies map[string]*pciTopology, n int) ([]xilinxDevice, error) {
+	if n > len(devices) {
+		return nil, fmt.Errorf("%d device(s) requested, but only %d available", n, len(devices))
+	}
+	if n <= 1 {
+		return devices[:n], nil
+	}
+
+	groups := make(map[string][]xilinxDevice)
+	depths := make(map[string]int)
+	keys := []string{}
+	for _, device := range devices {
+		topology, ok := topologies[device.DBDF]
+		if !ok {
+			continue
+		}
+		for depth := 0; depth <= len(topology.bridges); depth++ {
+			key := topology.key(depth)
+			if _, exist := groups[key]; !exist {
+				keys = append(keys, key)
+				depths[key] = depth
+			}
+			groups[key] = append(groups[key], device)
+		}
+	}
+
+	best := ""
+	for _, key := range keys {
+		if len(groups[key]) >= n && (best == "" || depths[key] > depths[best]) {
+			best = key
+		}
+	}
+	if best == "" {
+		// No common upstream bridge, devices are under different root buses
+		return devices[:n], nil
+	}
+	return groups[best][:n], nil
+}
+
+// allocate devices by count while creating the container, recording them in XILINX_VISIBLE_DEVICES
+func (r xilinxContainerRuntime) allocateDevices(spec *specs.Spec) error {
+	countEnv := getSpecEnv(spec, envXLNXDeviceCount)
+	if countEnv == "" {
+		countEnv = os.Getenv(envXLNXDeviceCount)
+	}
+	if countEnv == "" || getEmulationMode(spec) != "" {
+		return nil
+	}
+	if getSpecEnv(spec, envXLNXVisibleDevices) != "" || getSpecEnv(spec, envXLNXVisibleCards) != "" {
+		r.logger.Infof("Visible devices are specified, ignoring %s", envXLNXDeviceCount)
+		return nil
+	}
+
+	count, err := strconv.Atoi(countEnv)
+	if err != nil || count < 0 {
+		return fmt.Errorf("only non-negative int numbers allowed for env %s", envXLNXDeviceCount)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	functionEnv := getSpecEnv(spec, envXLNXDeviceFunction)
+	if functionEnv == "" {
+		functionEnv = os.Getenv(envXLNXDeviceFunction)
+	}
+	function, err := getDeviceFunction(functionEnv)
+	if err != nil {
+		return err
+	}
+
+	allDevices, err := getAllXilinxDevices()
+	if err != nil {
+		return err
+	}
+	exclusions, err := r.getDeviceExclusions()
+	if err != nil {
+		return err
+	}
+
+	exclusive := r.deviceExclusiveEnabled(spec)
+	freeDevices := []xilinxDevice{}
+	topologies := make(map[string]*pciTopology)
+	for _, device := range allDevices {
+		if !matchDeviceFunction(device, function) {
+			continue
+		}
+		if getDeviceState(device, exclusions) != deviceStateReady {
+			continue
+		}
+		if (exclusive && exclusions.Devices[device.DBDF] != 0) || exclusions.Devices[device.DBDF] == -1 {
+			continue
+		}
+		// Platform devices are not on the PCIe hierarchy
+		topology := &pciTopology{}
+		if !isPlatformDevice(device) {
+			topology, err = getPCITopology(device.DBDF)
+			if err != nil {
+				return err
+			}
+		}
+		freeDevices = append(freeDevices, device)
+		topologies[device.DBDF] = topology
+	}
+
+	selected, err := selectDevicesByTopology(freeDevices, topologies, count)
+	if err != nil {
+		return err
+	}
+
+	DBDFs := []string{}
+	for _, device := range selected {
+		DBDFs = append(DBDFs, device.DBDF)
+	}
+	r.logger.Infof("Allocated device(s) %v for this container", DBDFs)
+	setSpecEnv(spec, envXLNXVisibleDevices, strings.Join(DBDFs, ","))
+	return nil
+}
+
+// Grouping of a device into a card, and how it was derived
+type cardGroup struct {
+	key  string // devices with the same key belong to the same card, empty for a single device card
+	note string
+}
+
+// Return physical PCIe slots by the bus address of the device in the slot, like '0000:3b:00'
+func getPCISlots() map[string]string {
+	slots := make(map[string]string)
+	files, err := ioutil.ReadDir(hostPath(SysfsSlots))
+	if err != nil {
+		return slots
+	}
+	for _, file := range files {
+		address, err := getFileContent(hostPath(path.Join(SysfsSlots, file.Name(), SlotAddressFile)))
+		if err != nil || address == "" {
+			continue
+		}
+		slots[address] = file.Name()
+	}
+	return slots
+}
+
+/*
+Return the PCIe topology and the catalog product of every PCI function on
+host, product being empty for functions not in the catalog, like bridges.
+*/
+func getPCIFunctions() (map[string]*pciTopology, map[string]string) {
+	topologies := make(map[string]*pciTopology)
+	products := make(map[string]string)
+	files, err := ioutil.ReadDir(hostPath(SysfsDevices))
+	if err != nil {
+		return topologies, products
+	}
+	for _, file := range files {
+		DBDF := file.Name()
+		topology, err := getPCITopology(DBDF)
+		if err != nil {
+			continue
+		}
+		topologies[DBDF] = topology
+		vendorID, _ := getFileContent(hostPath(path.Join(SysfsDevices, DBDF, VendorFile)))
+		deviceID, _ := getFileContent(hostPath(path.Join(SysfsDevices, DBDF, DeviceFile)))
+		products[DBDF] = catalog.getProduct(vendorID, deviceID)
+	}
+	return topologies, products
+}
+
+/*
+Return the bridges with only the functions of one multi-device card below
+them, like the on-card PCIe switch connecting the two devices of u30.
+Single-device cards can be behind a host PCIe switch as well, so all
+functions below the bridge must belong to one multi-device product in the
+catalog, and they must not be more devices than on one card of that product.
+*/
+func getCardBridges(topologies map[string]*pciTopology, products map[string]string) map[string]bool {
+	isBridge := make(map[string]bool)
+	for _, topology := range topologies {
+		for _, bridge := range topology.bridges {
+			isBridge[bridge] = true
+		}
+	}
+
+	bridgeProducts := make(map[string]string)
+	mixed := make(map[string]bool)
+	addresses := make(map[string]map[string]bool)
+	for DBDF, topology := range topologies {
+		if isBridge[DBDF] {
+			continue
+		}
+		product := products[DBDF]
+		for _, bridge := range topology.bridges {
+			if seen, ok := bridgeProducts[bridge]; product == "" || (ok && seen != product) {
+				mixed[bridge] = true
+			}
+			bridgeProducts[bridge] = product
+			if addresses[bridge] == nil {
+				addresses[bridge] = make(map[string]bool)
+			}
+			addresses[bridge][DBDF[:strings.LastIndex(DBDF, ".")]] = true
+		}
+	}
+
+	cardBridges := make(map[string]bool)
+	for bridge, product := range bridgeProducts {
+		cardDevices := catalog.getCardDevices(product)
+		if !mixed[bridge] && cardDevices > 1 && len(addresses[bridge]) <= cardDevices {
+			cardBridges[bridge] = true
+		}
+	}
+	return cardBridges
+}
+
+/*
+Return the card group of a device without serial number, which is not
+readable when the card management controller is being updated or on some
+cloud instances. Devices are grouped by the PCIe slot the card is plugged
+in. If slots are not exposed, they are grouped by the upstream port of the
+switch above them if it is an on-card switch, or else taken as single
+device cards.
+*/
+func getTopologyCardGroup(DBDF string, topology *pciTopology, slots map[string]string, cardBridges map[string]bool) cardGroup {
+	chain := append(append([]string{}, topology.bridges...), DBDF)
+	for _, function := range chain {
+		address := function[:strings.LastIndex(function, ".")]
+		if slot, ok := slots[address]; ok {
+			return cardGroup{
+				key:  "slot:" + slot,
+				note: "no serial number, grouped by PCIe slot " + slot,
+			}
+		}
+	}
+
+	// A device right under a root port is a card of its own
+	if len(topology.bridges) < 2 {
+		return cardGroup{note: "no serial number"}
+	}
+	bridge := topology.bridges[len(topology.bridges)-2]
+	if !cardBridges[bridge] {
+		return cardGroup{note: "no serial number"}
+	}
+	return cardGroup{
+		key:  "bridge:" + bridge,
+		note: "no serial number, grouped by upstream PCIe bridge " + bridge,
+	}
+}
+
+// Return the card group of a device, by serial number or else by PCIe topology
+func getCardGroup(device xilinxDevice, slots map[string]string, cardBridges map[string]bool) cardGroup {
+	if strings.TrimSpace(device.SN) != "" {
+		return cardGroup{key: "sn:" + device.SN}
+	}
+	if isPlatformDevice(device) {
+		return cardGroup{note: "no serial number"}
+	}
+	topology, err := getPCITopology(device.DBDF)
+	if err != nil {
+		return cardGroup{note: "no serial number"}
+	}
+	return getTopologyCardGroup(device.DBDF, topology, slots, cardBridges)
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePCITopology(t *testing.T) {
+	topology := parsePCITopology("/sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:08.0/0000:03:00.1")
+	require.Equal(t, "pci0000:00", topology.root)
+	require.Equal(t, []string{"0000:00:01.0", "0000:01:00.0", "0000:02:08.0"}, topology.bridges)
+	require.Equal(t, "pci0000:00>0000:00:01.0>0000:01:00.0>0000:02:08.0", topology.String())
+}
+
+func TestSelectDevicesByTopology(t *testing.T) {
+	paths := map[string]string{
+		"0000:03:00.1": "/sys/devices/pci0000:00/0000:00:01.0/0000:03:00.1",
+		"0000:05:00.1": "/sys/devices/pci0000:00/0000:00:02.0/0000:04:00.0/0000:05:00.1",
+		"0000:06:00.1": "/sys/devices/pci0000:00/0000:00:02.0/0000:04:08.0/0000:06:00.1",
+		"0000:83:00.1": "/sys/devices/pci0000:80/0000:80:01.0/0000:81:00.0/0000:82:00.0/0000:83:00.1",
+		"0000:84:00.1": "/sys/devices/pci0000:80/0000:80:01.0/0000:81:00.0/0000:82:08.0/0000:84:00.1",
+	}
+	devices := []xilinxDevice{}
+	topologies := make(map[string]*pciTopology)
+	for _, DBDF := range []string{"0000:03:00.1", "0000:05:00.1", "0000:06:00.1", "0000:83:00.1", "0000:84:00.1"} {
+		devices = append(devices, xilinxDevice{DBDF: DBDF})
+		topologies[DBDF] = parsePCITopology(paths[DBDF])
+	}
+
+	testCases := []struct {
+		n        int
+		expected []string
+	}{
+		{
+			n:        1,
+			expected: []string{"0000:03:00.1"},
+		},
+		{
+			n:        2,
+			expected: []string{"0000:83:00.1", "0000:84:00.1"},
+		},
+		{
+			n:        3,
+			expected: []string{"0000:03:00.1", "0000:05:00.1", "0000:06:00.1"},
+		},
+		{
+			n:        4,
+			expected: []string{"0000:03:00.1", "0000:05:00.1", "0000:06:00.1", "0000:83:00.1"},
+		},
+	}
+
+	for i, tc := range testCases {
+		selected, err := selectDevicesByTopology(devices, topologies, tc.n)
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		DBDFs := []string{}
+		for _, device := range selected {
+			DBDFs = append(DBDFs, device.DBDF)
+		}
+		require.Equalf(t, tc.expected, DBDFs, "%d: %v", i, tc)
+	}
+
+	_, err := selectDevicesByTopology(devices, topologies, 6)
+	require.Error(t, err)
+}
+
+func TestGroupXilinxCards(t *testing.T) {
+	paths := map[string]string{
+		// single device card behind a bridge on its own root port
+		"0000:05:00.1": "/sys/devices/pci0000:00/0000:00:01.0/0000:03:00.0/0000:05:00.1",
+		// u30 with two devices behind its on-card switch
+		"0000:06:00.0": "/sys/devices/pci0000:00/0000:00:02.0/0000:04:00.0/0000:04:08.0/0000:06:00.0",
+		"0000:06:00.1": "/sys/devices/pci0000:00/0000:00:02.0/0000:04:00.0/0000:04:08.0/0000:06:00.1",
+		"0000:07:00.0": "/sys/devices/pci0000:00/0000:00:02.0/0000:04:00.0/0000:04:10.0/0000:07:00.0",
+		"0000:07:00.1": "/sys/devices/pci0000:00/0000:00:02.0/0000:04:00.0/0000:04:10.0/0000:07:00.1",
+		// two u50 cards behind a host switch
+		"0000:0b:00.1": "/sys/devices/pci0000:00/0000:00:03.0/0000:09:00.0/0000:0a:08.0/0000:0b:00.1",
+		"0000:0c:00.1": "/sys/devices/pci0000:00/0000:00:03.0/0000:09:00.0/0000:0a:10.0/0000:0c:00.1",
+		// two u30 cards behind a host switch, one device each is shown
+		"0000:12:00.1": "/sys/devices/pci0000:00/0000:00:04.0/0000:10:00.0/0000:11:08.0/0000:12:00.1",
+		"0000:13:00.1": "/sys/devices/pci0000:00/0000:00:04.0/0000:10:00.0/0000:11:10.0/0000:13:00.1",
+		"0000:14:00.1": "/sys/devices/pci0000:00/0000:00:04.0/0000:10:00.0/0000:11:18.0/0000:14:00.1",
+		"0000:15:00.1": "/sys/devices/pci0000:00/0000:00:04.0/0000:10:00.0/0000:11:20.0/0000:15:00.1",
+		"0000:83:00.1": "/sys/devices/pci0000:80/0000:80:01.0/0000:83:00.1",
+		"0000:84:00.1": "/sys/devices/pci0000:80/0000:80:02.0/0000:84:00.1",
+	}
+	products := map[string]string{
+		"0000:05:00.1": "u50",
+		"0000:06:00.0": "u30",
+		"0000:06:00.1": "u30",
+		"0000:07:00.0": "u30",
+		"0000:07:00.1": "u30",
+		"0000:0b:00.1": "u50",
+		"0000:0c:00.1": "u50",
+		"0000:12:00.1": "u30",
+		"0000:13:00.1": "u30",
+		"0000:14:00.1": "u30",
+		"0000:15:00.1": "u30",
+		"0000:83:00.1": "u250",
+		"0000:84:00.1": "u250",
+	}
+	topologies := make(map[string]*pciTopology)
+	for DBDF, p := range paths {
+		topologies[DBDF] = parsePCITopology(p)
+	}
+	cardBridges := getCardBridges(topologies, products)
+	// upstream ports of host switches are not taken as cards
+	require.True(t, cardBridges["0000:04:00.0"])
+	require.False(t, cardBridges["0000:09:00.0"])
+	require.False(t, cardBridges["0000:10:00.0"])
+
+	testCases := []struct {
+		slots    map[string]string
+		expected [][]string
+		notes    []string
+	}{
+		{
+			slots: map[string]string{},
+			expected: [][]string{
+				{"0000:00:1e.0", "0000:00:1f.0"},
+				{"0000:05:00.1"},
+				{"0000:06:00.1", "0000:07:00.1"},
+				{"0000:0b:00.1"},
+				{"0000:0c:00.1"},
+				{"0000:12:00.1"},
+				{"0000:13:00.1"},
+				{"0000:14:00.1"},
+				{"0000:15:00.1"},
+				{"0000:83:00.1"},
+				{"0000:84:00.1"},
+			},
+			notes: []string{
+				"",
+				"no serial number",
+				"no serial number, grouped by upstream PCIe bridge 0000:04:00.0",
+				"no serial number",
+				"no serial number",
+				"no serial number",
+				"no serial number",
+				"no serial number",
+				"no serial number",
+				"no serial number",
+				"no serial number",
+			},
+		},
+		{
+			slots: map[string]string{"0000:04:00": "3", "0000:83:00": "5", "0000:12:00": "7", "0000:13:00": "7"},
+			expected: [][]string{
+				{"0000:00:1e.0", "0000:00:1f.0"},
+				{"0000:05:00.1"},
+				{"0000:06:00.1", "0000:07:00.1"},
+				{"0000:0b:00.1"},
+				{"0000:0c:00.1"},
+				{"0000:12:00.1", "0000:13:00.1"},
+				{"0000:14:00.1"},
+				{"0000:15:00.1"},
+				{"0000:83:00.1"},
+				{"0000:84:00.1"},
+			},
+			notes: []string{
+				"",
+				"no serial number",
+				"no serial number, grouped by PCIe slot 3",
+				"no serial number",
+				"no serial number",
+				"no serial number, grouped by PCIe slot 7",
+				"no serial number",
+				"no serial number",
+				"no serial number, grouped by PCIe slot 5",
+				"no serial number",
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		devices := []xilinxDevice{
+			{DBDF: "0000:00:1e.0", SN: "XFL1YV0M20E0"},
+			{DBDF: "0000:00:1f.0", SN: "XFL1YV0M20E0"},
+		}
+		groups := make(map[string]cardGroup)
+		for _, device := range devices {
+			groups[device.DBDF] = cardGroup{key: "sn:" + device.SN}
+		}
+		for _, DBDF := range []string{"0000:05:00.1", "0000:06:00.1", "0000:07:00.1", "0000:0b:00.1", "0000:0c:00.1",
+			"0000:12:00.1", "0000:13:00.1", "0000:14:00.1", "0000:15:00.1", "0000:83:00.1", "0000:84:00.1"} {
+			devices = append(devices, xilinxDevice{DBDF: DBDF})
+			groups[DBDF] = getTopologyCardGroup(DBDF, topologies[DBDF], tc.slots, cardBridges)
+		}
+
+		cards := groupXilinxCards(devices, groups)
+		DBDFs := [][]string{}
+		notes := []string{}
+		for _, card := range cards {
+			cardDBDFs := []string{}
+			for _, device := range card.devices {
+				cardDBDFs = append(cardDBDFs, device.DBDF)
+			}
+			DBDFs = append(DBDFs, cardDBDFs)
+			notes = append(notes, card.note)
+		}
+		require.Equalf(t, tc.expected, DBDFs, "%d: %v", i, tc)
+		require.Equalf(t, tc.notes, notes, "%d: %v", i, tc)
+	}
+}
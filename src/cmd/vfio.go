@@ -0,0 +1,313 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	envXLNXVFIO        = "XILINX_VFIO"
+	SysfsIOMMUGroups   = "/sys/kernel/iommu_groups"
+	SysfsPCIDrivers    = "/sys/bus/pci/drivers"
+	SysfsDriversProbe  = "/sys/bus/pci/drivers_probe"
+	VFIOPrefix         = "/dev/vfio"
+	VFIOContainer      = "/dev/vfio/vfio"
+	VFIODriver         = "vfio-pci"
+	IOMMUGroupLink     = "iommu_group"
+	IOMMUGroupDevices  = "devices"
+	DriverOverrideFile = "driver_override"
+	UnbindFile         = "unbind"
+	BindFile           = "bind"
+	vfioDeviceFileMode = 0666
+)
+
+// vfioManager binds PCI functions to vfio-pci through sysfs, with all paths under the host root
+type vfioManager struct {
+	logger *log.Logger
+}
+
+// Return the IOMMU group of a PCI function
+func (m vfioManager) getIOMMUGroup(DBDF string) (string, error) {
+	link, err := os.Readlink(hostPath(path.Join(SysfsDevices, DBDF, IOMMUGroupLink)))
+	if err != nil {
+		return "", fmt.Errorf("Can't get IOMMU group of %s, is IOMMU enabled?", DBDF)
+	}
+	return path.Base(link), nil
+}
+
+// Return all PCI functions in an IOMMU group
+func (m vfioManager) getIOMMUGroupDevices(group string) ([]string, error) {
+	dir := hostPath(path.Join(SysfsIOMMUGroups, group, IOMMUGroupDevices))
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read folder %s", dir)
+	}
+	DBDFs := []string{}
+	for _, file := range files {
+		DBDFs = append(DBDFs, file.Name())
+	}
+	return DBDFs, nil
+}
+
+// Return the driver bound to a PCI function, empty if not bound
+func (m vfioManager) getDriver(DBDF string) string {
+	link, err := os.Readlink(hostPath(path.Join(SysfsDevices, DBDF, DriverLink)))
+	if err != nil {
+		return ""
+	}
+	return path.Base(link)
+}
+
+// Write content into a sysfs file
+func (m vfioManager) writeSysfs(file string, content string) error {
+	err := ioutil.WriteFile(hostPath(file), []byte(content), 0200)
+	if err != nil {
+		return fmt.Errorf("error writing '%s' to %s: %v", strings.TrimSpace(content), file, err)
+	}
+	return nil
+}
+
+// check the IOMMU group contains only Xilinx functions, so that passing it through does not expose other devices
+func (m vfioManager) checkIOMMUGroup(group string) ([]string, error) {
+	DBDFs, err := m.getIOMMUGroupDevices(group)
+	if err != nil {
+		return nil, err
+	}
+	for _, DBDF := range DBDFs {
+		vendorID, err := getFileContent(hostPath(path.Join(SysfsDevices, DBDF, VendorFile)))
+		if err != nil {
+			return nil, err
+		}
+		if !isXilinxVendor(vendorID) {
+			return nil, fmt.Errorf("IOMMU group %s contains non Xilinx function %s (vendor %s)", group, DBDF, vendorID)
+		}
+	}
+	return DBDFs, nil
+}
+
+// Bind a PCI function to vfio-pci, returning the driver bound before
+func (m vfioManager) bind(DBDF string) (string, error) {
+	driver := m.getDriver(DBDF)
+	if driver == VFIODriver {
+		return driver, nil
+	}
+
+	err := m.writeSysfs(path.Join(SysfsDevices, DBDF, DriverOverrideFile), VFIODriver)
+	if err != nil {
+		return "", err
+	}
+	if driver != "" {
+		err = m.writeSysfs(path.Join(SysfsDevices, DBDF, DriverLink, UnbindFile), DBDF)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = m.writeSysfs(SysfsDriversProbe, DBDF)
+	if err != nil {
+		return "", err
+	}
+	m.logger.Infof("Function %s rebound from driver '%s' to %s", DBDF, driver, VFIODriver)
+	return driver, nil
+}
+
+// Restore the driver of a PCI function bound to vfio-pci
+func (m vfioManager) restore(DBDF string, driver string) error {
+	if m.getDriver(DBDF) == VFIODriver {
+		err := m.writeSysfs(path.Join(SysfsDevices, DBDF, DriverLink, UnbindFile), DBDF)
+		if err != nil {
+			return err
+		}
+	}
+	// An empty line clears the driver override
+	err := m.writeSysfs(path.Join(SysfsDevices, DBDF, DriverOverrideFile), "\n")
+	if err != nil {
+		return err
+	}
+	// The function was not bound to any driver originally, or rebinding failed before unbinding it
+	if driver == "" || m.getDriver(DBDF) == driver {
+		return nil
+	}
+	err = m.writeSysfs(path.Join(SysfsPCIDrivers, driver, BindFile), DBDF)
+	if err != nil {
+		return err
+	}
+	m.logger.Infof("Function %s rebound to driver %s", DBDF, driver)
+	return nil
+}
+
+// Return the VFIO manager of this runtime
+func (r xilinxContainerRuntime) getVFIOManager() vfioManager {
+	return vfioManager{
+		logger: r.logger,
+	}
+}
+
+// check if VFIO passthrough is enabled for this container
+func (r xilinxContainerRuntime) vfioEnabled(spec *specs.Spec) bool {
+	// VFIO mode of the container is recorded in annotation once devices are injected
+	if vfioAnnotation := spec.Annotations[annotationXLNXVFIO]; vfioAnnotation != "" {
+		return vfioAnnotation == "true"
+	}
+	vfioEnv := getSpecEnv(spec, envXLNXVFIO)
+	if vfioEnv != "" {
+		vfio, err := strconv.ParseBool(strings.ToLower(vfioEnv))
+		if err == nil {
+			return vfio
+		}
+		r.logger.Printf("error getting VFIO enable status %v", err)
+	}
+	return r.cfg.vfio
+}
+
+// add VFIO group and container nodes in OCI Spec, allowing them in cgroup
+func (r xilinxContainerRuntime) addVFIONode(spec *specs.Spec, node string) error {
+	for _, device := range spec.Linux.Devices {
+		if device.Path == node {
+			return nil
+		}
+	}
+
+	major, minor, err := getDeviceMajorMinor(hostPath(node))
+	if err != nil {
+		return fmt.Errorf("error getting device major and minor numbers of %s: %v", node, err)
+	}
+	fileMode := os.FileMode(vfioDeviceFileMode)
+	uid, gid := uint32(0), uint32(0)
+	spec.Linux.Devices = append(spec.Linux.Devices, specs.LinuxDevice{
+		Path:     node,
+		Type:     "c",
+		Major:    major,
+		Minor:    minor,
+		FileMode: &fileMode,
+		UID:      &uid,
+		GID:      &gid,
+	})
+	spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, specs.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   "c",
+		Major:  &major,
+		Minor:  &minor,
+		Access: "rwm",
+	})
+	return nil
+}
+
+/*
+Pass through IOMMU groups of devices, binding their functions to vfio-pci if
+configured. Functions are only rebound when the devices were claimed by the
+same command, like 'create', since only claimed devices are released and given
+back to their original drivers on 'delete'.
+*/
+func (r xilinxContainerRuntime) addVFIODevices(spec *specs.Spec, devices []xilinxDevice, claimed bool) error {
+	// An IOMMU group can't be attached to more than one VM
+	if !r.deviceExclusiveEnabled(spec) {
+		return fmt.Errorf("devices can only be passed through by VFIO in device exclusive mode")
+	}
+	m := r.getVFIOManager()
+
+	for _, device := range devices {
+		group, err := m.getIOMMUGroup(device.DBDF)
+		if err != nil {
+			return err
+		}
+		DBDFs, err := m.checkIOMMUGroup(group)
+		if err != nil {
+			return err
+		}
+
+		for _, DBDF := range DBDFs {
+			if m.getDriver(DBDF) == VFIODriver {
+				continue
+			}
+			if !r.cfg.vfioRebind || !claimed {
+				return fmt.Errorf("function %s is not bound to %s", DBDF, VFIODriver)
+			}
+			// Record the original driver first, so that it can be restored even if rebinding or later steps fail
+			driver := m.getDriver(DBDF)
+			err = r.updateDeviceExclusions(func(exclusions *xilinxDeviceExclusions) error {
+				if _, ok := exclusions.Drivers[DBDF]; !ok {
+					exclusions.Drivers[DBDF] = driver
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			_, err = m.bind(DBDF)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = r.addVFIONode(spec, path.Join(VFIOPrefix, group))
+		if err != nil {
+			return err
+		}
+	}
+	return r.addVFIONode(spec, VFIOContainer)
+}
+
+// restore original drivers of functions in the IOMMU groups of released devices
+func (r xilinxContainerRuntime) releaseVFIODevices(devices []xilinxDevice) error {
+	m := r.getVFIOManager()
+	return r.updateDeviceExclusions(func(exclusions *xilinxDeviceExclusions) error {
+		if len(exclusions.Drivers) == 0 {
+			return nil
+		}
+		for _, device := range devices {
+			group, err := m.getIOMMUGroup(device.DBDF)
+			if err != nil {
+				return err
+			}
+			DBDFs, err := m.getIOMMUGroupDevices(group)
+			if err != nil {
+				return err
+			}
+			for _, DBDF := range DBDFs {
+				driver, rebound := exclusions.Drivers[DBDF]
+				if !rebound {
+					continue
+				}
+				err = m.restore(DBDF, driver)
+				if err != nil {
+					return err
+				}
+				delete(exclusions.Drivers, DBDF)
+			}
+		}
+		return nil
+	})
+}
+
+// Return the VFIO group nodes of devices, like /dev/vfio/42
+func (r xilinxContainerRuntime) getVFIONodes(device xilinxDevice) []string {
+	group, err := r.getVFIOManager().getIOMMUGroup(device.DBDF)
+	if err != nil {
+		return []string{}
+	}
+	return []string{path.Join(VFIOPrefix, group), VFIOContainer}
+}
@@ -0,0 +1,316 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+// create a fake sysfs tree with PCI functions in IOMMU group 42
+func createFakeIOMMUGroup(t *testing.T, root string, functions map[string]string) {
+	for _, driver := range []string{"xocl", "xclmgmt", VFIODriver} {
+		dir := path.Join(root, SysfsPCIDrivers, driver)
+		require.Nil(t, os.MkdirAll(dir, 0755))
+		require.Nil(t, os.WriteFile(path.Join(dir, UnbindFile), []byte{}, 0644))
+		require.Nil(t, os.WriteFile(path.Join(dir, BindFile), []byte{}, 0644))
+	}
+	require.Nil(t, os.WriteFile(path.Join(root, SysfsDriversProbe), []byte{}, 0644))
+
+	groupDir := path.Join(root, SysfsIOMMUGroups, "42", IOMMUGroupDevices)
+	require.Nil(t, os.MkdirAll(groupDir, 0755))
+	for DBDF, vendor := range functions {
+		dir := path.Join(root, SysfsDevices, DBDF)
+		require.Nil(t, os.MkdirAll(dir, 0755))
+		require.Nil(t, os.WriteFile(path.Join(dir, VendorFile), []byte(vendor+"\n"), 0644))
+		require.Nil(t, os.WriteFile(path.Join(dir, DriverOverrideFile), []byte("(null)\n"), 0644))
+		require.Nil(t, os.Symlink("../../../../kernel/iommu_groups/42", path.Join(dir, IOMMUGroupLink)))
+		require.Nil(t, os.Symlink("../../../../bus/pci/devices/"+DBDF, path.Join(groupDir, DBDF)))
+	}
+}
+
+func TestCheckIOMMUGroup(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	logger, _ := testlog.NewNullLogger()
+
+	testCases := []struct {
+		functions  map[string]string
+		shouldFail bool
+	}{
+		{
+			functions: map[string]string{"0000:3b:00.0": XilinxVendorID, "0000:3b:00.1": XilinxVendorID},
+		},
+		{
+			functions:  map[string]string{"0000:3b:00.1": XilinxVendorID, "0000:3b:00.2": "0x8086"},
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		hostRoot = t.TempDir()
+		m := vfioManager{logger: logger}
+		createFakeIOMMUGroup(t, hostRoot, tc.functions)
+
+		group, err := m.getIOMMUGroup("0000:3b:00.1")
+		require.Nil(t, err, "%d: %v", i, tc)
+		require.Equal(t, "42", group, "%d: %v", i, tc)
+
+		DBDFs, err := m.checkIOMMUGroup(group)
+		if tc.shouldFail {
+			require.NotNil(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.Nil(t, err, "%d: %v", i, tc)
+		require.Equal(t, len(tc.functions), len(DBDFs), "%d: %v", i, tc)
+	}
+}
+
+func TestBindAndRestoreVFIO(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	logger, _ := testlog.NewNullLogger()
+	hostRoot = t.TempDir()
+	m := vfioManager{logger: logger}
+	DBDF := "0000:3b:00.1"
+	createFakeIOMMUGroup(t, hostRoot, map[string]string{DBDF: XilinxVendorID})
+	require.Nil(t, os.Symlink("../../drivers/xocl", path.Join(hostRoot, SysfsDevices, DBDF, DriverLink)))
+
+	driver, err := m.bind(DBDF)
+	require.Nil(t, err)
+	require.Equal(t, "xocl", driver)
+
+	content, err := getFileContent(path.Join(hostRoot, SysfsDevices, DBDF, DriverOverrideFile))
+	require.Nil(t, err)
+	require.Equal(t, VFIODriver, content)
+	content, err = getFileContent(path.Join(hostRoot, SysfsPCIDrivers, "xocl", UnbindFile))
+	require.Nil(t, err)
+	require.Equal(t, DBDF, content)
+	content, err = getFileContent(path.Join(hostRoot, SysfsDriversProbe))
+	require.Nil(t, err)
+	require.Equal(t, DBDF, content)
+
+	// emulate the kernel binding the function to vfio-pci
+	link := path.Join(hostRoot, SysfsDevices, DBDF, DriverLink)
+	require.Nil(t, os.Remove(link))
+	require.Nil(t, os.Symlink("../../drivers/"+VFIODriver, link))
+
+	err = m.restore(DBDF, driver)
+	require.Nil(t, err)
+	content, err = getFileContent(path.Join(hostRoot, SysfsDevices, DBDF, DriverOverrideFile))
+	require.Nil(t, err)
+	require.Equal(t, "", content)
+	content, err = getFileContent(path.Join(hostRoot, SysfsPCIDrivers, VFIODriver, UnbindFile))
+	require.Nil(t, err)
+	require.Equal(t, DBDF, content)
+	content, err = getFileContent(path.Join(hostRoot, SysfsPCIDrivers, "xocl", BindFile))
+	require.Nil(t, err)
+	require.Equal(t, DBDF, content)
+}
+
+func TestRestoreVFIONotRebound(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	logger, _ := testlog.NewNullLogger()
+	hostRoot = t.TempDir()
+	m := vfioManager{logger: logger}
+	DBDF := "0000:3b:00.1"
+	createFakeIOMMUGroup(t, hostRoot, map[string]string{DBDF: XilinxVendorID})
+	require.Nil(t, os.Symlink("../../drivers/xocl", path.Join(hostRoot, SysfsDevices, DBDF, DriverLink)))
+	require.Nil(t, os.WriteFile(path.Join(hostRoot, SysfsDevices, DBDF, DriverOverrideFile), []byte(VFIODriver), 0644))
+
+	// rebinding failed while the function was still bound to its original driver
+	err := m.restore(DBDF, "xocl")
+	require.Nil(t, err)
+	content, err := getFileContent(path.Join(hostRoot, SysfsDevices, DBDF, DriverOverrideFile))
+	require.Nil(t, err)
+	require.Equal(t, "", content)
+	content, err = getFileContent(path.Join(hostRoot, SysfsPCIDrivers, "xocl", BindFile))
+	require.Nil(t, err)
+	require.Equal(t, "", content)
+}
+
+func TestVFIOEnabled(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{vfio: false},
+	}
+
+	testCases := []struct {
+		env         []string
+		annotations map[string]string
+		enabled     bool
+	}{
+		{
+			env: []string{},
+		},
+		{
+			env:     []string{"XILINX_VFIO=true"},
+			enabled: true,
+		},
+		{
+			env:         []string{"XILINX_VFIO=true"},
+			annotations: map[string]string{annotationXLNXVFIO: "false"},
+		},
+		{
+			env:         []string{},
+			annotations: map[string]string{annotationXLNXVFIO: "true"},
+			enabled:     true,
+		},
+	}
+
+	for i, tc := range testCases {
+		spec := &specs.Spec{
+			Process:     &specs.Process{Env: tc.env},
+			Annotations: tc.annotations,
+		}
+		require.Equal(t, tc.enabled, shim.vfioEnabled(spec), "%d: %v", i, tc)
+		if tc.enabled {
+			require.False(t, shim.deviceAliasEnabled(spec), "%d: %v", i, tc)
+		}
+	}
+}
+
+func TestKeepUndiscoveredDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+		},
+	}
+	exclusions, err := shim.getDeviceExclusions()
+	require.Nil(t, err)
+	exclusions.Devices["0000:3b:00.1"] = -1
+	exclusions.Drivers["0000:3b:00.1"] = "xocl"
+	exclusions.Drivers["0000:3b:00.0"] = "xclmgmt"
+	require.Nil(t, shim.setDeviceExclusions(exclusions))
+
+	testCases := []struct {
+		DBDFs    string
+		devices  []xilinxDevice
+		expected []string
+	}{
+		{
+			DBDFs:    "0000:3b:00.1",
+			expected: []string{"0000:3b:00.1"},
+		},
+		{
+			// functions not rebound by the runtime are ignored
+			DBDFs:    "0000:3b:00.1,0000:5e:00.1",
+			expected: []string{"0000:3b:00.1"},
+		},
+		{
+			DBDFs:    "0000:d8:00.1,0000:5e:00.1",
+			devices:  []xilinxDevice{{DBDF: "0000:d8:00.1", Pair: &xilinxPair{}}},
+			expected: []string{"0000:d8:00.1"},
+		},
+	}
+
+	for i, tc := range testCases {
+		devices, err := shim.keepUndiscoveredDevices(tc.DBDFs, tc.devices)
+		require.Nil(t, err, "%d: %v", i, tc)
+		DBDFs := []string{}
+		for _, device := range devices {
+			DBDFs = append(DBDFs, device.DBDF)
+		}
+		require.Equal(t, tc.expected, DBDFs, "%d: %v", i, tc)
+	}
+}
+
+func TestRemoveDeviceAnnotations(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{logger: logger, cfg: &config{}}
+	spec := &specs.Spec{
+		Annotations: map[string]string{
+			annotationXLNXDevices: "0000:3b:00.1",
+			annotationXLNXMode:    deviceModeExclusive,
+			annotationXLNXVFIO:    "true",
+			annotationXLNXXclbin:  "/opt/xclbin/kernel.xclbin",
+		},
+	}
+	require.Nil(t, shim.removeDeviceAnnotations(spec))
+	require.Equal(t, map[string]string{annotationXLNXXclbin: "/opt/xclbin/kernel.xclbin"}, spec.Annotations)
+}
+
+func TestAddVFIODevices(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	defer func(nodes map[string][2]int64) { snapshotNodes = nodes }(snapshotNodes)
+	logger, _ := testlog.NewNullLogger()
+	DBDF := "0000:3b:00.1"
+
+	testCases := []struct {
+		env        []string
+		claimed    bool
+		shouldFail bool
+	}{
+		{
+			env:     []string{"XILINX_DEVICE_EXCLUSIVE=true"},
+			claimed: true,
+		},
+		{
+			// devices of 'run' are never claimed, so functions can't be rebound
+			env:        []string{"XILINX_DEVICE_EXCLUSIVE=true"},
+			shouldFail: true,
+		},
+		{
+			// IOMMU groups can't be shared by containers
+			env:        []string{"XILINX_DEVICE_EXCLUSIVE=false"},
+			claimed:    true,
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		hostRoot = t.TempDir()
+		createFakeIOMMUGroup(t, hostRoot, map[string]string{DBDF: XilinxVendorID})
+		require.Nil(t, os.Symlink("../../drivers/xocl", path.Join(hostRoot, SysfsDevices, DBDF, DriverLink)))
+		snapshotNodes = map[string][2]int64{
+			hostPath("/dev/vfio/42"): {243, 0},
+			hostPath(VFIOContainer):  {10, 196},
+		}
+		shim := xilinxContainerRuntime{
+			logger: logger,
+			cfg: &config{
+				exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+				vfioRebind:        true,
+			},
+		}
+		spec := &specs.Spec{
+			Process: &specs.Process{Env: tc.env},
+			Linux:   &specs.Linux{Resources: &specs.LinuxResources{}},
+		}
+
+		err := shim.addVFIODevices(spec, []xilinxDevice{{DBDF: DBDF, Pair: &xilinxPair{}}}, tc.claimed)
+		if tc.shouldFail {
+			require.NotNil(t, err, "%d: %v", i, tc)
+			content, err := getFileContent(path.Join(hostRoot, SysfsDevices, DBDF, DriverOverrideFile))
+			require.Nil(t, err, "%d: %v", i, tc)
+			require.Equal(t, "(null)", content, "%d: %v", i, tc)
+			continue
+		}
+		require.Nil(t, err, "%d: %v", i, tc)
+		require.Equal(t, 2, len(spec.Linux.Devices), "%d: %v", i, tc)
+		exclusions, err := shim.getDeviceExclusions()
+		require.Nil(t, err, "%d: %v", i, tc)
+		require.Equal(t, map[string]string{DBDF: "xocl"}, exclusions.Drivers, "%d: %v", i, tc)
+	}
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	envXLNXXclbin        = "XILINX_XCLBIN"
+	annotationXLNXXclbin = "com.xilinx.xclbin"
+	xclbinLoaderXclbin   = "{xclbin}"
+)
+
+// xclbinLoader programs an xclbin onto a device
+type xclbinLoader interface {
+	Load(device xilinxDevice, xclbin string) error
+}
+
+// commandXclbinLoader programs xclbin by running an external command, like 'xbutil program'
+type commandXclbinLoader struct {
+	logger  *log.Logger
+	command string
+	timeout time.Duration
+}
+
+var _ xclbinLoader = (*commandXclbinLoader)(nil)
+
+// Run the loader command with '{bdf}' and '{xclbin}' replaced by the device and xclbin file
+func (l commandXclbinLoader) Load(device xilinxDevice, xclbin string) error {
+	placeholders := map[string]string{deviceCommandBDF: device.DBDF, xclbinLoaderXclbin: xclbin}
+	err := runDeviceCommand(l.logger, l.command, placeholders, l.timeout)
+	if err != nil {
+		return fmt.Errorf("xclbin loader failed on device %s: %v", device.DBDF, err)
+	}
+	return nil
+}
+
+// get xclbin paths inside the container based on environment variable or annotation
+func getXclbinPaths(spec *specs.Spec) []string {
+	xclbinEnv := getSpecEnv(spec, envXLNXXclbin)
+	if xclbinEnv == "" && spec.Annotations != nil {
+		xclbinEnv = spec.Annotations[annotationXLNXXclbin]
+	}
+
+	xclbins := []string{}
+	for _, part := range strings.Split(xclbinEnv, ",") {
+		if strings.TrimSpace(part) != "" {
+			xclbins = append(xclbins, strings.TrimSpace(part))
+		}
+	}
+	return xclbins
+}
+
+// check whether xclbin was built for the shell on device
+func checkXclbinOnDevice(xclbin *axlf, device xilinxDevice) error {
+	// Only xocl devices run shells with xclbins, embedded platforms and AMI devices have no shell to compare with
+	if device.driver == zoclDriver || device.driver == amiDriver {
+		return nil
+	}
+
+	deviceUUIDs, err := getInterfaceUUIDs(device.DBDF)
+	if err != nil {
+		return err
+	}
+
+	// Interface uuids identify the shell exactly, use VBNV only if not available
+	if len(xclbin.interfaceUUIDs) != 0 && len(deviceUUIDs) != 0 {
+		for _, uuid := range xclbin.interfaceUUIDs {
+			found := false
+			for _, deviceUUID := range deviceUUIDs {
+				if uuid == deviceUUID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("interface uuid %s of xclbin is not provided by device %s with shell %s",
+					uuid, device.DBDF, device.shellVer)
+			}
+		}
+		return nil
+	}
+
+	if xclbin.platformVBNV != device.shellVer {
+		return fmt.Errorf("xclbin built for platform %s can't be used on device %s with shell %s",
+			xclbin.platformVBNV, device.DBDF, device.shellVer)
+	}
+	return nil
+}
+
+// check xclbins requested by the container are compatible with assigned devices while creating the container
+func (r xilinxContainerRuntime) checkXclbinCompatibility(spec *specs.Spec) error {
+	policy := strings.ToLower(r.cfg.xclbinCheck)
+	if policy == xrtCheckIgnore {
+		return nil
+	}
+	if policy != xrtCheckWarn && policy != xrtCheckDeny {
+		return fmt.Errorf("unknown xclbin check policy '%s'", r.cfg.xclbinCheck)
+	}
+
+	xclbins := getXclbinPaths(spec)
+	if len(xclbins) == 0 {
+		return nil
+	}
+
+	visibleXilinxDevices, err := r.getVisibleDevices(spec)
+	if err != nil {
+		return err
+	} else if len(visibleXilinxDevices) == 0 {
+		return nil
+	}
+
+	rootfs := r.getRootfsPath(spec)
+	for _, xclbinPath := range xclbins {
+		// xclbin paths are named by the container, and must not lead out of its rootfs
+		hostXclbinPath, err := resolveRootfsPath(rootfs, xclbinPath)
+		if err != nil {
+			return err
+		}
+		xclbin, err := parseAxlf(hostXclbinPath)
+		if err != nil {
+			return err
+		}
+		r.logger.Infof("xclbin %s built for platform %s", xclbinPath, xclbin.platformVBNV)
+
+		for _, device := range visibleXilinxDevices {
+			err = checkXclbinOnDevice(xclbin, device)
+			if err == nil {
+				continue
+			}
+			if policy == xrtCheckDeny {
+				return fmt.Errorf("xclbin %s: %v", xclbinPath, err)
+			}
+			r.logger.Warnf("xclbin %s: %v", xclbinPath, err)
+		}
+	}
+	return nil
+}
+
+// get the xclbin loader, which is configured by the config file unless replaced
+func (r xilinxContainerRuntime) getXclbinLoader() xclbinLoader {
+	if r.loader != nil {
+		return r.loader
+	}
+	return commandXclbinLoader{
+		logger:  r.logger,
+		command: r.cfg.xclbinLoader,
+		timeout: time.Duration(r.cfg.xclbinLoadTimeout) * time.Second,
+	}
+}
+
+// program xclbin onto assigned devices while creating the container
+func (r xilinxContainerRuntime) programXclbins(spec *specs.Spec) error {
+	if !r.cfg.xclbinProgram {
+		return nil
+	}
+
+	xclbinPaths := getXclbinPaths(spec)
+	if len(xclbinPaths) == 0 {
+		return nil
+	}
+
+	visibleXilinxDevices, err := r.getVisibleDevices(spec)
+	if err != nil {
+		return err
+	} else if len(visibleXilinxDevices) == 0 {
+		return nil
+	}
+
+	// The loader runs as root on host, so xclbins must never be taken from outside the rootfs
+	rootfs := r.getRootfsPath(spec)
+	xclbins := []*axlf{}
+	hostXclbinPaths := []string{}
+	for _, xclbinPath := range xclbinPaths {
+		hostXclbinPath, err := resolveRootfsPath(rootfs, xclbinPath)
+		if err != nil {
+			return err
+		}
+		xclbin, err := parseAxlf(hostXclbinPath)
+		if err != nil {
+			return err
+		}
+		xclbins = append(xclbins, xclbin)
+		hostXclbinPaths = append(hostXclbinPaths, hostXclbinPath)
+	}
+
+	exclusions, err := r.getDeviceExclusions()
+	if err != nil {
+		return err
+	}
+
+	loader := r.getXclbinLoader()
+	for _, device := range visibleXilinxDevices {
+		// AMI devices are not programmed with xclbins
+		if device.driver == amiDriver {
+			continue
+		}
+		// Pick the first xclbin built for the device
+		index := -1
+		for i, xclbin := range xclbins {
+			if checkXclbinOnDevice(xclbin, device) == nil {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			r.logger.Warnf("No xclbin is built for device %s, skipping programming it", device.DBDF)
+			continue
+		}
+		xclbin := xclbins[index]
+
+		if exclusions.Xclbins[device.DBDF] == xclbin.uuid {
+			r.logger.Infof("xclbin %s is loaded on device %s already", xclbin.uuid, device.DBDF)
+			continue
+		}
+		if exclusions.Devices[device.DBDF] > 1 {
+			return fmt.Errorf("can't program device %s, which is being used by other containers", device.DBDF)
+		}
+
+		r.logger.Infof("Programming xclbin %s onto device %s", xclbinPaths[index], device.DBDF)
+		err = loader.Load(device, hostXclbinPaths[index])
+		// The xclbin on the device is unknown after a failed load
+		updateErr := r.updateDeviceExclusions(func(exclusions *xilinxDeviceExclusions) error {
+			if err != nil {
+				delete(exclusions.Xclbins, device.DBDF)
+			} else {
+				exclusions.Xclbins[device.DBDF] = xclbin.uuid
+			}
+			return nil
+		})
+		if err != nil {
+			if updateErr != nil {
+				r.logger.Warnf("%v", updateErr)
+			}
+			return err
+		}
+		if updateErr != nil {
+			return updateErr
+		}
+	}
+	return nil
+}
+
+// print header information of xclbin files
+func printXclbins(fnames []string) error {
+	for _, fname := range fnames {
+		xclbin, err := parseAxlf(fname)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "File:\t\t\t%s\n", fname)
+		fmt.Fprintf(os.Stderr, "Version:\t\t%s\n", xclbin.version)
+		fmt.Fprintf(os.Stderr, "UUID:\t\t\t%s\n", xclbin.uuid)
+		fmt.Fprintf(os.Stderr, "Platform VBNV:\t\t%s\n", xclbin.platformVBNV)
+		fmt.Fprintf(os.Stderr, "Interface UUIDs:\t%s\n", strings.Join(xclbin.interfaceUUIDs, ","))
+		fmt.Fprintf(os.Stderr, "Created:\t\t%s\n", time.Unix(int64(xclbin.timestamp), 0).Format(time.RFC3339))
+		fmt.Fprintf(os.Stderr, "Size:\t\t\t%d\n", xclbin.length)
+	}
+	return nil
+}
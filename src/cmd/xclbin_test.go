@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandXclbinLoader(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	device := xilinxDevice{DBDF: "0000:3b:00.1"}
+
+	testCases := []struct {
+		command    string
+		shouldFail bool
+	}{
+		{
+			command: "echo {bdf} {xclbin}",
+		},
+		{
+			command:    "false",
+			shouldFail: true,
+		},
+		{
+			command:    "sleep 5",
+			shouldFail: true,
+		},
+		{
+			command:    "",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		loader := commandXclbinLoader{
+			logger:  logger,
+			command: tc.command,
+			timeout: 200 * time.Millisecond,
+		}
+		err := loader.Load(device, "/rootfs/test.xclbin")
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+	}
+}
+
+// stubXclbinLoader records devices programmed instead of programming them
+type stubXclbinLoader struct {
+	loaded []string
+	err    error
+}
+
+func (l *stubXclbinLoader) Load(device xilinxDevice, xclbin string) error {
+	l.loaded = append(l.loaded, device.DBDF+" "+path.Base(xclbin))
+	return l.err
+}
+
+func TestProgramXclbins(t *testing.T) {
+	vbnv := "xilinx_u250_gen3x16_xdma_shell_3_1"
+	uuid := "deadbeef000000000000000000000000"
+	rootfs := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(rootfs, "test.xclbin"),
+		buildXclbin(vbnv, "2D0D2A38-4F2B-4B21-A0B7-C6B8E7E1C5A3"), 0644))
+	// A valid xclbin on host, outside the rootfs
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(outside, "host.xclbin"),
+		buildXclbin(vbnv, "2D0D2A38-4F2B-4B21-A0B7-C6B8E7E1C5A3"), 0644))
+	require.NoError(t, os.Symlink(path.Join("..", path.Base(outside), "host.xclbin"), path.Join(rootfs, "host.xclbin")))
+
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	inventory = &deviceInventory{backends: []discoveryBackend{fakeBackend{
+		devices: []xilinxDevice{
+			{DBDF: "0000:3b:00.1", shellVer: vbnv, driver: "xocl", Pair: &xilinxPair{}, state: deviceStateReady},
+			{DBDF: "0000:5e:00.1", shellVer: "xilinx_u50_gen3x16_xdma_base_5", driver: "xocl", Pair: &xilinxPair{}, state: deviceStateReady},
+		},
+	}}}
+
+	testCases := []struct {
+		devices    string
+		xclbin     string // xclbin named by the container, /test.xclbin if empty
+		users      int    // containers using the device, including this one
+		loadedUUID string // xclbin recorded on the device before programming
+		loadErr    error
+		loaded     []string
+		recorded   string // xclbin recorded on the device after programming
+		shouldFail bool
+	}{
+		{
+			devices:  "0000:3b:00.1",
+			users:    1,
+			loaded:   []string{"0000:3b:00.1 test.xclbin"},
+			recorded: uuid,
+		},
+		{
+			// the same xclbin is not programmed again
+			devices:    "0000:3b:00.1",
+			users:      2,
+			loadedUUID: uuid,
+			recorded:   uuid,
+		},
+		{
+			// devices used by other containers are not programmed
+			devices:    "0000:3b:00.1",
+			users:      2,
+			loadedUUID: "0123",
+			recorded:   "0123",
+			shouldFail: true,
+		},
+		{
+			devices:    "0000:3b:00.1",
+			users:      1,
+			loadedUUID: "0123",
+			loadErr:    fmt.Errorf("load failed"),
+			loaded:     []string{"0000:3b:00.1 test.xclbin"},
+			shouldFail: true,
+		},
+		{
+			// devices without a matching xclbin are skipped
+			devices: "0000:5e:00.1",
+			users:   1,
+		},
+		{
+			// xclbins outside the rootfs are never programmed
+			devices:    "0000:3b:00.1",
+			xclbin:     "/host.xclbin",
+			users:      1,
+			shouldFail: true,
+		},
+		{
+			devices:    "0000:3b:00.1",
+			xclbin:     "../" + path.Base(outside) + "/host.xclbin",
+			users:      1,
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		logger, _ := testlog.NewNullLogger()
+		loader := &stubXclbinLoader{err: tc.loadErr}
+		shim := xilinxContainerRuntime{
+			logger: logger,
+			cfg: &config{
+				exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+				xclbinProgram:     true,
+			},
+			loader: loader,
+		}
+		xclbin := tc.xclbin
+		if xclbin == "" {
+			xclbin = "/test.xclbin"
+		}
+		spec := &specs.Spec{
+			Root: &specs.Root{Path: rootfs},
+			Process: &specs.Process{Env: []string{
+				envXLNXVisibleDevices + "=" + tc.devices,
+				envXLNXXclbin + "=" + xclbin,
+			}},
+		}
+
+		exclusions, err := shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		exclusions.Devices[tc.devices] = tc.users
+		if tc.loadedUUID != "" {
+			exclusions.Xclbins[tc.devices] = tc.loadedUUID
+		}
+		require.NoErrorf(t, shim.setDeviceExclusions(exclusions), "%d: %v", i, tc)
+
+		err = shim.programXclbins(spec)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+		require.Equalf(t, tc.loaded, loader.loaded, "%d: %v", i, tc)
+		exclusions, err = shim.getDeviceExclusions()
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, tc.recorded, exclusions.Xclbins[tc.devices], "%d: %v", i, tc)
+	}
+}
+
+func TestProgramXclbinsRollback(t *testing.T) {
+	vbnv := "xilinx_u250_gen3x16_xdma_shell_3_1"
+	rootfs := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(rootfs, "test.xclbin"),
+		buildXclbin(vbnv, "2D0D2A38-4F2B-4B21-A0B7-C6B8E7E1C5A3"), 0644))
+
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	inventory = &deviceInventory{backends: []discoveryBackend{fakeBackend{
+		devices: []xilinxDevice{{DBDF: "0000:3b:00.1", shellVer: vbnv, driver: "xocl", Pair: &xilinxPair{}, state: deviceStateReady}},
+	}}}
+
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+			xclbinProgram:     true,
+		},
+		loader: &stubXclbinLoader{err: fmt.Errorf("load failed")},
+	}
+	spec := &specs.Spec{
+		Root: &specs.Root{Path: rootfs},
+		Process: &specs.Process{Env: []string{
+			envXLNXVisibleDevices + "=0000:3b:00.1",
+			envXLNXXclbin + "=/test.xclbin",
+		}},
+	}
+
+	// create claims the device, and releases it again when programming fails
+	require.NoError(t, shim.addDeviceExclusions(spec))
+	require.Error(t, shim.programXclbins(spec))
+	require.NoError(t, shim.deleteDeviceExclusions(spec))
+
+	exclusions, err := shim.getDeviceExclusions()
+	require.NoError(t, err)
+	require.Equal(t, 0, exclusions.Devices["0000:3b:00.1"])
+	require.Empty(t, exclusions.Xclbins)
+}
@@ -20,10 +20,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Xilinx/xilinx-container-runtime/src/pkg/oci"
@@ -35,32 +38,39 @@ const (
 	envXLNXVisibleDevices  = "XILINX_VISIBLE_DEVICES"
 	envXLNXVisibleCards    = "XILINX_VISIBLE_CARDS"
 	envXLNXDeviceExclusive = "XILINX_DEVICE_EXCLUSIVE"
+	maxSymlinks            = 255 // symlinks followed while resolving a path, like the kernel does
 )
 
 // xilinxContainerRuntime wraps specified runtime, conditionally modifying OCI spec before invoking the spcified runtime
 type xilinxContainerRuntime struct {
-	logger  *log.Logger
-	cfg     *config
-	runtime oci.Runtime
-	ocispec oci.Spec
-	mutex   *sync.Mutex
+	logger    *log.Logger
+	cfg       *config
+	runtime   oci.Runtime
+	ocispec   oci.Spec
+	bundleDir string
+	loader    xclbinLoader
+	mutex     *sync.Mutex
 }
 
 type xilinxDeviceExclusions struct {
-	Notice  string         `json:"notice"`
-	Devices map[string]int `json:"devices"`
+	Notice  string            `json:"notice"`
+	Devices map[string]int    `json:"devices"`
+	Xclbins map[string]string `json:"xclbins,omitempty"` // uuid of xclbin loaded by the runtime on each device
+	States  map[string]string `json:"states,omitempty"`  // transient device states, like 'resetting'
+	Drivers map[string]string `json:"drivers,omitempty"` // original drivers of functions rebound to vfio-pci
 }
 
 var _ oci.Runtime = (*xilinxContainerRuntime)(nil)
 
 // Constructor for xilinx container runtime
-func newXilinxContainerRuntimeWithLogger(logger *log.Logger, cfg *config, runtime oci.Runtime, ociSpec oci.Spec) (oci.Runtime, error) {
+func newXilinxContainerRuntimeWithLogger(logger *log.Logger, cfg *config, runtime oci.Runtime, ociSpec oci.Spec, bundleDir string) (oci.Runtime, error) {
 	r := xilinxContainerRuntime{
-		logger:  logger,
-		cfg:     cfg,
-		runtime: runtime,
-		ocispec: ociSpec,
-		mutex:   new(sync.Mutex),
+		logger:    logger,
+		cfg:       cfg,
+		runtime:   runtime,
+		ocispec:   ociSpec,
+		bundleDir: bundleDir,
+		mutex:     new(sync.Mutex),
 	}
 
 	return &r, nil
@@ -168,12 +178,123 @@ func (r xilinxContainerRuntime) forwardingRequired(args []string) bool {
 	return true
 }
 
+// get value of environment variable from OCI Spec
+func getSpecEnv(spec *specs.Spec, key string) string {
+	if spec.Process == nil {
+		return ""
+	}
+	for _, str := range spec.Process.Env {
+		parts := strings.SplitN(str, "=", 2)
+		if len(parts) == 2 && parts[0] == key {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// set environment variable in OCI Spec, replacing the existing value if any
+func setSpecEnv(spec *specs.Spec, key string, value string) {
+	if spec.Process == nil {
+		spec.Process = &specs.Process{}
+	}
+	for i, str := range spec.Process.Env {
+		if strings.SplitN(str, "=", 2)[0] == key {
+			spec.Process.Env[i] = key + "=" + value
+			return
+		}
+	}
+	spec.Process.Env = append(spec.Process.Env, key+"="+value)
+}
+
+// get the container root filesystem path on host
+func (r xilinxContainerRuntime) getRootfsPath(spec *specs.Spec) string {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return ""
+	}
+	if path.IsAbs(spec.Root.Path) {
+		return spec.Root.Path
+	}
+	return path.Join(r.bundleDir, spec.Root.Path)
+}
+
+/*
+Resolve a path inside the container to a path on host under the rootfs.
+Symlinks are followed as they would be inside the container, absolute link
+targets being relative to the rootfs, and paths leading out of the rootfs,
+either by '..' or by symlinks, are rejected. Paths named by the container,
+like xclbin files, must be resolved by this before being opened on host.
+*/
+func resolveRootfsPath(rootfs string, p string) (string, error) {
+	if rootfs == "" {
+		return "", fmt.Errorf("can't resolve path %s without the container rootfs", p)
+	}
+	resolved := "/"
+	pending := strings.Split(p, "/")
+	links := 0
+	for len(pending) != 0 {
+		part := pending[0]
+		pending = pending[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			if resolved == "/" {
+				return "", fmt.Errorf("path %s leads out of the container rootfs", p)
+			}
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := os.Lstat(path.Join(rootfs, next))
+		if err != nil {
+			return "", fmt.Errorf("error resolving path %s in the container rootfs: %v", p, err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("error resolving path %s in the container rootfs: too many symlinks", p)
+		}
+		target, err := os.Readlink(path.Join(rootfs, next))
+		if err != nil {
+			return "", fmt.Errorf("error resolving path %s in the container rootfs: %v", p, err)
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return path.Join(rootfs, resolved), nil
+}
+
 // get visible devices list based on environment variables
 func (r xilinxContainerRuntime) getVisibleDevices(spec *specs.Spec) ([]xilinxDevice, error) {
 	visibleDevicesEnv := ""
 	visibleCardsEnv := ""
 	var visibleXilinxDevices []xilinxDevice
 
+	// No device is used in emulation mode
+	if mode := getEmulationMode(spec); mode != "" {
+		// Devices are not skipped silently for a mistyped mode
+		if err := checkEmulationMode(mode); err != nil {
+			return nil, err
+		}
+		r.logger.Infof("Emulation mode %s requested, skipping device discovery", mode)
+		return nil, nil
+	}
+
+	// Devices injected into the container are recorded in annotation
+	if DBDFs := spec.Annotations[annotationXLNXDevices]; DBDFs != "" {
+		devices, err := getXilinxDevicesByDeviceEnv(DBDFs)
+		if err != nil {
+			return nil, fmt.Errorf("error getting xilinx devices: %v", err)
+		}
+		return r.keepUndiscoveredDevices(DBDFs, devices)
+	}
+
 	if spec.Process != nil && spec.Process.Env != nil {
 		// Check environment variable from OCI Spec file
 		for _, str := range spec.Process.Env {
@@ -222,8 +343,56 @@ func (r xilinxContainerRuntime) getVisibleDevices(spec *specs.Spec) ([]xilinxDev
 	return visibleXilinxDevices, nil
 }
 
+/*
+Devices rebound to vfio-pci are no longer discovered through xocl sysfs
+entries, keep them in the list by their DBDFs so that they can still be
+released. Only functions the runtime rebound itself, recorded with their
+original drivers in the device exclusion file, are kept.
+*/
+func (r xilinxContainerRuntime) keepUndiscoveredDevices(DBDFs string, devices []xilinxDevice) ([]xilinxDevice, error) {
+	exclusions, err := r.getDeviceExclusions()
+	if err != nil {
+		return nil, err
+	}
+	discovered := make(map[string]bool)
+	for _, device := range devices {
+		discovered[device.DBDF] = true
+	}
+	for _, DBDF := range strings.Split(DBDFs, ",") {
+		if discovered[DBDF] {
+			continue
+		}
+		if exclusions.Drivers[DBDF] == "" {
+			r.logger.Warnf("Ignoring device %s, which is neither discovered nor rebound to %s by the runtime", DBDF, VFIODriver)
+			continue
+		}
+		r.logger.Infof("Device %s is not discovered, it is bound to %s", DBDF, VFIODriver)
+		devices = append(devices, xilinxDevice{
+			DBDF: DBDF,
+			Pair: &xilinxPair{},
+		})
+	}
+	return devices, nil
+}
+
+// remove device annotations from the spec given to create, which are only written by the runtime
+func (r xilinxContainerRuntime) removeDeviceAnnotations(spec *specs.Spec) error {
+	for _, annotation := range []string{annotationXLNXDevices, annotationXLNXMode, annotationXLNXVFIO} {
+		if _, ok := spec.Annotations[annotation]; ok {
+			r.logger.Warnf("Ignoring annotation %s set before creating the container", annotation)
+			delete(spec.Annotations, annotation)
+		}
+	}
+	return nil
+}
+
 // check if device exclusive is enabled for this container
 func (r xilinxContainerRuntime) deviceExclusiveEnabled(spec *specs.Spec) bool {
+	// Device mode of the container is recorded in annotation once devices are injected
+	if mode := spec.Annotations[annotationXLNXMode]; mode != "" {
+		return mode == deviceModeExclusive
+	}
+
 	deviceExclusiveEnv := ""
 	if spec.Process != nil && spec.Process.Env != nil {
 		// Check environment variable from OCI Spec file
@@ -258,13 +427,16 @@ Get current device exclusion stats from file, -1 meaning device is being
 used by a container exclusively, non-negtive integers meaning the
 number of containers are sharing the device
 */
-func (r xilinxContainerRuntime) getDeviceExclusions() (map[string]int, error) {
+func (r xilinxContainerRuntime) getDeviceExclusions() (*xilinxDeviceExclusions, error) {
 	exclusions := xilinxDeviceExclusions{
 		Notice:  "",
 		Devices: make(map[string]int),
+		Xclbins: make(map[string]string),
+		States:  make(map[string]string),
+		Drivers: make(map[string]string),
 	}
 	if _, err := os.Stat(r.cfg.exclusionFilePath); os.IsNotExist(err) {
-		return exclusions.Devices, nil
+		return &exclusions, nil
 	}
 
 	file, err := os.Open(r.cfg.exclusionFilePath)
@@ -281,50 +453,119 @@ func (r xilinxContainerRuntime) getDeviceExclusions() (map[string]int, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading device exclusions from file: %v", err)
 	}
+	if exclusions.Devices == nil {
+		exclusions.Devices = make(map[string]int)
+	}
+	if exclusions.Xclbins == nil {
+		exclusions.Xclbins = make(map[string]string)
+	}
+	if exclusions.States == nil {
+		exclusions.States = make(map[string]string)
+	}
+	if exclusions.Drivers == nil {
+		exclusions.Drivers = make(map[string]string)
+	}
 
-	return exclusions.Devices, nil
+	return &exclusions, nil
 }
 
 // save device exclusion stats into file
-func (r xilinxContainerRuntime) setDeviceExclusions(devices map[string]int) error {
-	file, err := os.Create(r.cfg.exclusionFilePath)
+func (r xilinxContainerRuntime) setDeviceExclusions(exclusions *xilinxDeviceExclusions) error {
+	// Write a temporary file and rename it, so that runtimes reading the file never see it partially written
+	file, err := ioutil.TempFile(path.Dir(r.cfg.exclusionFilePath), path.Base(r.cfg.exclusionFilePath))
 	if err != nil {
 		return fmt.Errorf("error opening device exclusion file: %v", err)
 	}
 
+	defer os.Remove(file.Name())
 	defer file.Close()
 
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
 
 	currentTime := time.Now().Format("2006-01-02 3:4:5 pm")
-	exclusions := xilinxDeviceExclusions{
-		Notice: fmt.Sprintf(
-			"This file stores the status of xilinx devices usage, which was saved on %s. '-1' means the device is being used exclusively. 0 or positive integer is the number of containers currently using respective device.",
-			currentTime),
-		Devices: devices,
-	}
+	exclusions.Notice = fmt.Sprintf(
+		"This file stores the status of xilinx devices usage, which was saved on %s. '-1' means the device is being used exclusively. 0 or positive integer is the number of containers currently using respective device.",
+		currentTime)
 
 	err = encoder.Encode(exclusions)
 	if err != nil {
 		return fmt.Errorf("error writing device exclusions to file: %v", err)
 	}
+	if err = file.Chmod(0644); err != nil {
+		return fmt.Errorf("error writing device exclusions to file: %v", err)
+	}
+	if err = os.Rename(file.Name(), r.cfg.exclusionFilePath); err != nil {
+		return fmt.Errorf("error writing device exclusions to file: %v", err)
+	}
 	return nil
 }
 
-// modify OCI spec to add xilinx devices
-func (r xilinxContainerRuntime) modifyOCISpec() error {
-	err := r.ocispec.Load()
+/*
+Lock the device exclusion file against other runtime processes, and return
+the function to unlock it. A separate lock file is locked, as the exclusion
+file is replaced on every write.
+*/
+func (r xilinxContainerRuntime) lockDeviceExclusions() (func(), error) {
+	file, err := os.OpenFile(r.cfg.exclusionFilePath+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening device exclusion lock: %v", err)
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error locking device exclusion file: %v", err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// read, update and write device exclusions at once, while holding the lock of the exclusion file
+func (r xilinxContainerRuntime) updateDeviceExclusions(update func(*xilinxDeviceExclusions) error) error {
+	unlock, err := r.lockDeviceExclusions()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	exclusions, err := r.getDeviceExclusions()
+	if err != nil {
+		return err
+	}
+	err = update(exclusions)
+	if err != nil {
+		return err
+	}
+	return r.setDeviceExclusions(exclusions)
+}
+
+// modify the loaded OCI spec to add xilinx devices
+func (r xilinxContainerRuntime) modifyOCISpec(readinessTimeout time.Duration, claimed bool) error {
+	err := r.ocispec.Modify(r.waitVisibleDevicesReady(readinessTimeout))
 	if err != nil {
-		return fmt.Errorf("error loading OCI specification for modification: %v", err)
+		return fmt.Errorf("Devices are not ready: %v", err)
 	}
 
-	err = r.ocispec.Modify(r.addXilinxDevices)
+	err = r.ocispec.Modify(func(spec *specs.Spec) error {
+		return r.addXilinxDevices(spec, claimed)
+	})
 
 	if err != nil {
 		return fmt.Errorf("error adding Xilinx devices in OCI Spec: %v", err)
 	}
 
+	err = r.ocispec.Modify(r.addEmulation)
+	if err != nil {
+		return fmt.Errorf("error setting up emulation in OCI Spec: %v", err)
+	}
+
+	err = r.ocispec.Modify(r.addHostXrt)
+	if err != nil {
+		return fmt.Errorf("error adding host XRT in OCI Spec: %v", err)
+	}
+
 	err = r.ocispec.Flush()
 	if err != nil {
 		return fmt.Errorf("error writing modified OCI specification: %v", err)
@@ -343,44 +584,48 @@ func (r xilinxContainerRuntime) addDeviceExclusions(spec *specs.Spec) error {
 		r.logger.Infof("Updating device exclusions status for %d device(s)", len(visibleXilinxDevices))
 	}
 
-	// get current device exclusion status from file
-	deviceExclusions, err := r.getDeviceExclusions()
-	if err != nil {
-		return err
-	}
-
-	// check whether it is in device exclusive mode
-	if r.deviceExclusiveEnabled(spec) {
-		// In device exclucsive mode, assign device to this container only if the current device exclusion value is 0
+	/*
+		Devices must have been ready already. Their states are checked again
+		in the same locked update claiming them, so that they can't be
+		released for reset by another runtime in between.
+	*/
+	visibleXilinxDevices = refreshXilinxDevices(visibleXilinxDevices)
+	isExclusiveMode := r.deviceExclusiveEnabled(spec)
+	r.logger.Printf("Trying to updated device exclusion status to file.")
+	return r.updateDeviceExclusions(func(exclusions *xilinxDeviceExclusions) error {
 		for _, device := range visibleXilinxDevices {
-			if deviceExclusions[device.DBDF] != 0 {
-				r.logger.Printf("Device %s is being used by another container", device.DBDF)
-				return fmt.Errorf("Device %s is being used by another container", device.DBDF)
-			} else {
-				r.logger.Printf("Device %s will be used exclusively by this container", device.DBDF)
-				deviceExclusions[device.DBDF] = -1
+			if state := getDeviceState(device, exclusions); state != deviceStateReady {
+				return fmt.Errorf("Device %s is %s", device.DBDF, state)
 			}
 		}
-	} else {
-		// Not in device exclusive mode, assign device to this container if current device exclusion value is not -1
-		for _, device := range visibleXilinxDevices {
-			if deviceExclusions[device.DBDF] == -1 {
-				r.logger.Printf("Device %s is being used exclusively by another container", device.DBDF)
-				return fmt.Errorf("Device %s is being used exclusively by another container", device.DBDF)
-			} else {
-				r.logger.Printf("Device %s will be used by this container", device.DBDF)
-				deviceExclusions[device.DBDF] = deviceExclusions[device.DBDF] + 1
+
+		deviceExclusions := exclusions.Devices
+		// check whether it is in device exclusive mode
+		if isExclusiveMode {
+			// In device exclucsive mode, assign device to this container only if the current device exclusion value is 0
+			for _, device := range visibleXilinxDevices {
+				if deviceExclusions[device.DBDF] != 0 {
+					r.logger.Printf("Device %s is being used by another container", device.DBDF)
+					return fmt.Errorf("Device %s is being used by another container", device.DBDF)
+				} else {
+					r.logger.Printf("Device %s will be used exclusively by this container", device.DBDF)
+					deviceExclusions[device.DBDF] = -1
+				}
+			}
+		} else {
+			// Not in device exclusive mode, assign device to this container if current device exclusion value is not -1
+			for _, device := range visibleXilinxDevices {
+				if deviceExclusions[device.DBDF] == -1 {
+					r.logger.Printf("Device %s is being used exclusively by another container", device.DBDF)
+					return fmt.Errorf("Device %s is being used exclusively by another container", device.DBDF)
+				} else {
+					r.logger.Printf("Device %s will be used by this container", device.DBDF)
+					deviceExclusions[device.DBDF] = deviceExclusions[device.DBDF] + 1
+				}
 			}
 		}
-	}
-
-	// flush the device exclusion status into file
-	r.logger.Printf("Trying to updated device exclusion status to file.")
-	err = r.setDeviceExclusions(deviceExclusions)
-	if err != nil {
-		return err
-	}
-	return nil
+		return nil
+	})
 }
 
 // delete device exclusions while deleting the container
@@ -396,45 +641,111 @@ func (r xilinxContainerRuntime) deleteDeviceExclusions(spec *specs.Spec) error {
 		r.logger.Infof("There is %d device(s) used in this container", len(visibleXilinxDevices))
 	}
 
-	// get current device exclusion status from file
-	deviceExclusions, err := r.getDeviceExclusions()
+	/*
+		Released devices are marked as resetting in the same locked update,
+		so that no other container can claim them before they are reset.
+	*/
+	isExclusiveMode := r.deviceExclusiveEnabled(spec)
+	releasedDevices := []xilinxDevice{}
+	r.logger.Printf("Trying to updated device exclusion status to file.")
+	err = r.updateDeviceExclusions(func(exclusions *xilinxDeviceExclusions) error {
+		deviceExclusions := exclusions.Devices
+		for _, device := range visibleXilinxDevices {
+			// Devices not claimed in the exclusion file were never assigned to a container
+			if deviceExclusions[device.DBDF] == 0 {
+				r.logger.Warnf("Device %s is not in use, skipping its release", device.DBDF)
+				continue
+			}
+			if isExclusiveMode {
+				// set the exclusion value 0 from -1
+				deviceExclusions[device.DBDF] = 0
+			} else {
+				// do a decrement from current value
+				deviceExclusions[device.DBDF] = deviceExclusions[device.DBDF] - 1
+			}
+			if deviceExclusions[device.DBDF] == 0 {
+				releasedDevices = append(releasedDevices, device)
+				if r.cfg.deviceReset {
+					exclusions.States[device.DBDF] = deviceStateResetting
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	isExclusiveMode := r.deviceExclusiveEnabled(spec)
+	// give devices passed through back to their original drivers
+	err = r.releaseVFIODevices(releasedDevices)
+	if err != nil {
+		return err
+	}
+
+	// reset devices no longer used by any container
+	return r.resetDevices(releasedDevices)
+}
+
+// check whether the destination is in the mount config already
+func mountExisted(spec *specs.Spec, destination string) bool {
+	for _, mount := range spec.Mounts {
+		if mount.Destination == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// mask sysfs directories and device nodes of xilinx devices on host not assigned to this container
+func (r xilinxContainerRuntime) maskInvisibleDevices(spec *specs.Spec, allDevices []xilinxDevice, visibleXilinxDevices []xilinxDevice) {
+	visible := make(map[string]bool)
 	for _, device := range visibleXilinxDevices {
-		if isExclusiveMode {
-			// set the exclusion value 0 from -1
-			deviceExclusions[device.DBDF] = 0
-		} else {
-			// do a decrement from current value
-			deviceExclusions[device.DBDF] = deviceExclusions[device.DBDF] - 1
+		visible[device.DBDF] = true
+	}
+
+	masked := make(map[string]bool)
+	for _, maskedPath := range spec.Linux.MaskedPaths {
+		masked[maskedPath] = true
+	}
+
+	for _, device := range allDevices {
+		if visible[device.DBDF] {
+			continue
+		}
+		paths := []string{
+			getDeviceSysfsDir(device),
+			device.Pair.User,
+			device.Pair.Mgmt,
+			device.Pair.Qdma,
+		}
+		if device.Pair.MgmtDBDF != "" {
+			paths = append(paths, path.Join(SysfsDevices, device.Pair.MgmtDBDF))
+		}
+		for _, p := range paths {
+			// Skip paths used by devices assigned to this container, like aliased nodes
+			if strings.TrimSpace(p) == "" || masked[p] || mountExisted(spec, p) {
+				continue
+			}
+			r.logger.Infof("Masking path %s of device %s", p, device.DBDF)
+			spec.Linux.MaskedPaths = append(spec.Linux.MaskedPaths, p)
+			masked[p] = true
 		}
 	}
+}
 
-	// flush the device exclusion status into file
-	r.logger.Printf("Trying to updated device exclusion status to file.")
-	err = r.setDeviceExclusions(deviceExclusions)
+// mount device nodes of xilinx devices and allow them in cgroup
+func (r xilinxContainerRuntime) mountXilinxDevices(spec *specs.Spec, visibleXilinxDevices []xilinxDevice) error {
+	err := r.createDeviceNodes(visibleXilinxDevices)
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-// add xilinx devices in OCI Spec
-func (r xilinxContainerRuntime) addXilinxDevices(spec *specs.Spec) error {
-	visibleXilinxDevices, err := r.getVisibleDevices(spec)
+	err = r.validateXilinxDevices(visibleXilinxDevices)
 	if err != nil {
 		return err
-	} else if visibleXilinxDevices == nil || len(visibleXilinxDevices) == 0 {
-		r.logger.Infof("There is no device to be mounted")
-		return nil
-	} else {
-		r.logger.Infof("There is %d device(s) to be mounted", len(visibleXilinxDevices))
 	}
 
-	for _, device := range visibleXilinxDevices {
+	for i, device := range visibleXilinxDevices {
 		// Check whether the device is in the mount config already
 		userMounted, mgmtMounted := false, false
 		for _, mount := range spec.Mounts {
@@ -452,7 +763,7 @@ func (r xilinxContainerRuntime) addXilinxDevices(spec *specs.Spec) error {
 		if !userMounted && len(strings.TrimSpace(device.Pair.User)) != 0 {
 			// Mount user node
 			spec.Mounts = append(spec.Mounts, specs.Mount{
-				Destination: device.Pair.User,
+				Destination: r.getContainerUserNode(spec, device, i),
 				Type:        "none",
 				Source:      device.Pair.User,
 				Options:     []string{"nosuid", "noexec", "bind"},
@@ -469,9 +780,31 @@ func (r xilinxContainerRuntime) addXilinxDevices(spec *specs.Spec) error {
 			})
 		}
 
+		// Expose the PCI sysfs directories of the device, and its management
+		// function if injected, read-only, which are required by XRT for
+		// device discovery inside the container
+		sysfsDirs := []string{getDeviceSysfsDir(device)}
+		if strings.TrimSpace(device.Pair.Mgmt) != "" && device.Pair.MgmtDBDF != "" {
+			sysfsDirs = append(sysfsDirs, path.Join(SysfsDevices, device.Pair.MgmtDBDF))
+		}
+		for _, sysfsDir := range sysfsDirs {
+			if !mountExisted(spec, sysfsDir) && fileExist(hostPath(sysfsDir)) {
+				spec.Mounts = append(spec.Mounts, specs.Mount{
+					Destination: sysfsDir,
+					Type:        "none",
+					Source:      sysfsDir,
+					Options:     []string{"ro", "nosuid", "noexec", "nodev", "rbind"},
+				})
+			}
+		}
+		err = r.aliasSysfsNode(spec, device, r.getContainerUserNode(spec, device, i))
+		if err != nil {
+			return err
+		}
+
 		// Check whether user device is mapped in Linux Devices config
 		deviceMapped := false
-		major, minor, err := getDeviceMajorMinor(device.Pair.User)
+		major, minor, err := getDeviceMajorMinor(hostPath(device.Pair.User))
 		for _, device := range spec.Linux.Resources.Devices {
 			if deviceMapped {
 				break
@@ -497,41 +830,148 @@ func (r xilinxContainerRuntime) addXilinxDevices(spec *specs.Spec) error {
 				Access: "rw",
 			})
 		}
+
+		if device.driver == zoclDriver {
+			r.addZoclMounts(spec)
+		}
 	}
 	return nil
 }
 
+// add xilinx devices in OCI Spec, claimed tells whether they were claimed by this command
+func (r xilinxContainerRuntime) addXilinxDevices(spec *specs.Spec, claimed bool) error {
+	visibleXilinxDevices, err := r.getVisibleDevices(spec)
+	if err != nil {
+		return err
+	} else if visibleXilinxDevices == nil || len(visibleXilinxDevices) == 0 {
+		r.logger.Infof("There is no device to be mounted")
+		return nil
+	} else {
+		r.logger.Infof("There is %d device(s) to be mounted", len(visibleXilinxDevices))
+	}
+
+	// Devices are passed through as whole PCI functions in VFIO mode
+	if r.vfioEnabled(spec) {
+		err = r.addVFIODevices(spec, visibleXilinxDevices, claimed)
+	} else {
+		err = r.mountXilinxDevices(spec, visibleXilinxDevices)
+	}
+	if err != nil {
+		return err
+	}
+
+	allDevices, err := getAllXilinxDevices()
+	if err != nil {
+		return fmt.Errorf("error getting xilinx devices: %v", err)
+	}
+	r.maskInvisibleDevices(spec, allDevices, visibleXilinxDevices)
+
+	err = r.addDeviceMetadata(spec, visibleXilinxDevices)
+	if err != nil {
+		return err
+	}
+
+	r.annotateXilinxDevices(spec, visibleXilinxDevices)
+	r.aliasXilinxDevices(spec, visibleXilinxDevices)
+
+	return r.pinNUMA(spec, visibleXilinxDevices)
+}
+
 // method to be called from main method
 func (r xilinxContainerRuntime) Exec(args []string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	// Update device exclusion status if required
-	if r.addDeviceExclusionsRequired(args) {
+	readinessTimeout := r.getReadinessTimeout(args)
+
+	// The OCI spec is loaded once, and kept in memory after being flushed
+	specLoaded := false
+	loadSpec := func() error {
+		if specLoaded {
+			return nil
+		}
 		err := r.ocispec.Load()
 		if err != nil {
 			return fmt.Errorf("error loading OCI specification for modification: %v", err)
 		}
+		specLoaded = true
+		return nil
+	}
+
+	// Devices claimed by this command are released again if the container can't be created
+	claimed := false
+	releaseClaimedDevices := func() {
+		if !claimed {
+			return
+		}
+		if err := r.ocispec.Modify(r.deleteDeviceExclusions); err != nil {
+			r.logger.Warnf("Fail to release devices: %v", err)
+		}
+	}
+
+	// Update device exclusion status if required
+	if r.addDeviceExclusionsRequired(args) {
+		err := loadSpec()
+		if err != nil {
+			return err
+		}
+		err = r.ocispec.Modify(r.removeDeviceAnnotations)
+		if err != nil {
+			return err
+		}
+		err = r.ocispec.Modify(r.allocateDevices)
+		if err != nil {
+			return fmt.Errorf("Fail to allocate devices: %v", err)
+		}
+		// Save allocated devices, which are read again by later commands
+		err = r.ocispec.Flush()
+		if err != nil {
+			return fmt.Errorf("error writing modified OCI specification: %v", err)
+		}
+		err = r.ocispec.Modify(r.waitVisibleDevicesReady(readinessTimeout))
+		if err != nil {
+			return fmt.Errorf("Devices are not ready: %v", err)
+		}
+		err = r.ocispec.Modify(r.checkXrtCompatibility)
+		if err != nil {
+			return fmt.Errorf("XRT version check failed: %v", err)
+		}
+		err = r.ocispec.Modify(r.checkXclbinCompatibility)
+		if err != nil {
+			return fmt.Errorf("xclbin check failed: %v", err)
+		}
 		err = r.ocispec.Modify(r.addDeviceExclusions)
 		if err != nil {
 			return fmt.Errorf("Fail to update device exclusion status: %v. Please refer to file %s for details",
 				err, r.cfg.exclusionFilePath)
 		}
+		claimed = true
+		err = r.ocispec.Modify(r.programXclbins)
+		if err != nil {
+			releaseClaimedDevices()
+			return fmt.Errorf("Fail to program xclbin: %v", err)
+		}
 	}
 
 	// Add xilinx devices in OCI Spec if required
 	if r.modificationRequired(args) {
-		err := r.modifyOCISpec()
+		err := loadSpec()
 		if err != nil {
+			return err
+		}
+		err = r.modifyOCISpec(readinessTimeout, claimed)
+		if err != nil {
+			// give back devices, and functions rebound to vfio-pci, if claimed above
+			releaseClaimedDevices()
 			return fmt.Errorf("Fail to modify OCI spec: %v", err)
 		}
 	}
 
 	// delete device exclusion status if required
 	if r.deleteDeviceExclusionsRequired(args) {
-		err := r.ocispec.Load()
+		err := loadSpec()
 		if err != nil {
-			return fmt.Errorf("error loading OCI specification for modification: %v", err)
+			return err
 		}
 		err = r.ocispec.Modify(r.deleteDeviceExclusions)
 		if err != nil {
@@ -20,8 +20,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"testing"
 
+	"github.com/Xilinx/xilinx-container-runtime/src/pkg/oci"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	testlog "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
@@ -59,7 +61,9 @@ func TestArgsGetConfigFilePath(t *testing.T) {
 func TestAddXilinxDevices(t *testing.T) {
 	logger, logHook := testlog.NewNullLogger()
 	shim := xilinxContainerRuntime{
-		logger: logger,
+		logger:    logger,
+		cfg:       &config{},
+		bundleDir: t.TempDir(),
 	}
 
 	testCases := []struct {
@@ -133,7 +137,7 @@ func TestAddXilinxDevices(t *testing.T) {
 			numMounts = len(tc.spec.Mounts)
 		}
 
-		err := shim.addXilinxDevices(tc.spec)
+		err := shim.addXilinxDevices(tc.spec, false)
 		require.NoErrorf(t, err, "%d: %v", i, tc)
 		if tc.shouldAdd {
 			require.Greater(t, len(tc.spec.Mounts), numMounts, "%d: %v", i, tc)
@@ -197,3 +201,232 @@ func TestModificationRequired(t *testing.T) {
 		require.Equal(t, tc.shouldModify, tc.shim.modificationRequired(tc.args), "%d: %v", i, tc)
 	}
 }
+
+func TestMountXilinxDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{},
+	}
+	defer func(root string) { hostRoot = root }(hostRoot)
+	defer func(nodes map[string][2]int64) { snapshotNodes = nodes }(snapshotNodes)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{
+		"sys/bus/pci/devices/0000:3b:00.0/mgmt_pf": "1",
+		"sys/bus/pci/devices/0000:3b:00.1/user_pf": "1",
+		"sys/bus/pci/devices/0000:5e:00.1/user_pf": "1",
+	})
+	snapshotNodes = map[string][2]int64{
+		hostPath("/dev/dri/renderD128"): {226, 128},
+		hostPath("/dev/dri/renderD129"): {226, 129},
+	}
+
+	testCases := []struct {
+		device       xilinxDevice
+		destinations []string
+	}{
+		{
+			device: xilinxDevice{DBDF: "0000:3b:00.1", Pair: &xilinxPair{
+				User: "/dev/dri/renderD128", Mgmt: "/dev/xclmgmt15104", MgmtDBDF: "0000:3b:00.0",
+			}},
+			destinations: []string{
+				"/dev/dri/renderD128",
+				"/dev/xclmgmt15104",
+				path.Join(SysfsDevices, "0000:3b:00.1"),
+				path.Join(SysfsDevices, "0000:3b:00.0"),
+			},
+		},
+		{
+			// the management function is only exposed with its node
+			device: xilinxDevice{DBDF: "0000:5e:00.1", Pair: &xilinxPair{
+				User: "/dev/dri/renderD129", MgmtDBDF: "0000:5e:00.0",
+			}},
+			destinations: []string{
+				"/dev/dri/renderD129",
+				path.Join(SysfsDevices, "0000:5e:00.1"),
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		spec := &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{}}}
+		require.NoErrorf(t, shim.mountXilinxDevices(spec, []xilinxDevice{tc.device}), "%d: %v", i, tc)
+
+		destinations := []string{}
+		for _, mount := range spec.Mounts {
+			destinations = append(destinations, mount.Destination)
+			require.Equalf(t, mount.Destination, mount.Source, "%d: %v", i, tc)
+		}
+		require.Equalf(t, tc.destinations, destinations, "%d: %v", i, tc)
+
+		major, minor := snapshotNodes[hostPath(tc.device.Pair.User)][0], snapshotNodes[hostPath(tc.device.Pair.User)][1]
+		require.Equalf(t, 1, len(spec.Linux.Resources.Devices), "%d: %v", i, tc)
+		require.Equalf(t, major, *spec.Linux.Resources.Devices[0].Major, "%d: %v", i, tc)
+		require.Equalf(t, minor, *spec.Linux.Resources.Devices[0].Minor, "%d: %v", i, tc)
+	}
+}
+
+func TestMaskInvisibleDevices(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg:    &config{},
+	}
+	allDevices := []xilinxDevice{
+		{DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD128", Mgmt: "/dev/xclmgmt15104", MgmtDBDF: "0000:3b:00.0"}},
+		{DBDF: "0000:5e:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD129", Mgmt: "/dev/xclmgmt24064", MgmtDBDF: "0000:5e:00.0", Qdma: "/dev/xfpga/qdma.u.24065"}},
+		{DBDF: "0000:d8:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD130"}},
+	}
+
+	testCases := []struct {
+		visible     []xilinxDevice
+		mounts      []specs.Mount
+		maskedPaths []string
+	}{
+		{
+			visible: allDevices[:1],
+			maskedPaths: []string{
+				"/proc/kcore",
+				"/sys/bus/pci/devices/0000:5e:00.1",
+				"/dev/dri/renderD129",
+				"/dev/xclmgmt24064",
+				"/dev/xfpga/qdma.u.24065",
+				"/sys/bus/pci/devices/0000:5e:00.0",
+				"/sys/bus/pci/devices/0000:d8:00.1",
+				"/dev/dri/renderD130",
+			},
+		},
+		{
+			// paths mounted for assigned devices, like aliased nodes, are not masked
+			visible: allDevices[2:],
+			mounts:  []specs.Mount{{Destination: "/dev/dri/renderD128", Source: "/dev/dri/renderD130"}},
+			maskedPaths: []string{
+				"/proc/kcore",
+				"/sys/bus/pci/devices/0000:3b:00.1",
+				"/dev/xclmgmt15104",
+				"/sys/bus/pci/devices/0000:3b:00.0",
+				"/sys/bus/pci/devices/0000:5e:00.1",
+				"/dev/dri/renderD129",
+				"/dev/xclmgmt24064",
+				"/dev/xfpga/qdma.u.24065",
+				"/sys/bus/pci/devices/0000:5e:00.0",
+			},
+		},
+		{
+			visible:     allDevices,
+			maskedPaths: []string{"/proc/kcore"},
+		},
+	}
+
+	for i, tc := range testCases {
+		spec := &specs.Spec{
+			Mounts: tc.mounts,
+			Linux:  &specs.Linux{MaskedPaths: []string{"/proc/kcore"}},
+		}
+		shim.maskInvisibleDevices(spec, allDevices, tc.visible)
+		require.Equalf(t, tc.maskedPaths, spec.Linux.MaskedPaths, "%d: %v", i, tc)
+	}
+}
+
+func TestExecReleasesClaimedDevices(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	hostRoot = t.TempDir()
+	inventory = &deviceInventory{backends: []discoveryBackend{
+		fakeBackend{
+			name: xoclDriver,
+			devices: []xilinxDevice{
+				{index: "0", DBDF: "0000:3b:00.1", Pair: &xilinxPair{User: "/dev/dri/renderD128"}, state: deviceStateReady},
+			},
+		},
+	}}
+
+	bundle := t.TempDir()
+	spec := `{"ociVersion": "1.0.2", "process": {"env": ["XILINX_VISIBLE_DEVICES=0"]}, "root": {"path": "rootfs"}, "linux": {}}`
+	require.NoError(t, os.WriteFile(path.Join(bundle, "config.json"), []byte(spec), 0644))
+
+	logger, _ := testlog.NewNullLogger()
+	cfg := &config{
+		exclusionFilePath: path.Join(t.TempDir(), "exclusion.json"),
+		deviceExclusive:   true,
+		deviceValidation:  true,
+		xrtVersionCheck:   xrtCheckIgnore,
+		xclbinCheck:       xrtCheckIgnore,
+	}
+	r, err := newXilinxContainerRuntimeWithLogger(logger, cfg, replayRuntime{}, oci.NewSpecFromFile(path.Join(bundle, "config.json")), bundle)
+	require.NoError(t, err)
+
+	// The device is claimed, but fails validation since no driver is bound to it
+	err = r.Exec([]string{"xilinx-container-runtime", "create", "--bundle", bundle, "test"})
+	require.Error(t, err)
+
+	shim := xilinxContainerRuntime{logger: logger, cfg: cfg}
+	exclusions, err := shim.getDeviceExclusions()
+	require.NoError(t, err)
+	require.Equal(t, 0, exclusions.Devices["0000:3b:00.1"])
+}
+
+func TestResolveRootfsPath(t *testing.T) {
+	rootfs := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(rootfs, "opt/xclbin"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(rootfs, "opt/xclbin/kernel.xclbin"), []byte{}, 0644))
+	require.NoError(t, os.Symlink("/opt/xclbin", path.Join(rootfs, "xclbin")))
+	require.NoError(t, os.Symlink("../xclbin/kernel.xclbin", path.Join(rootfs, "opt/kernel.xclbin")))
+	require.NoError(t, os.Symlink("/etc/shadow", path.Join(rootfs, "shadow")))
+	require.NoError(t, os.Symlink("../../../../etc/shadow", path.Join(rootfs, "opt/shadow")))
+	require.NoError(t, os.Symlink("loop", path.Join(rootfs, "loop")))
+
+	testCases := []struct {
+		path       string
+		resolved   string
+		shouldFail bool
+	}{
+		{
+			path:     "/opt/xclbin/kernel.xclbin",
+			resolved: "opt/xclbin/kernel.xclbin",
+		},
+		{
+			path:     "opt/./xclbin/../xclbin/kernel.xclbin",
+			resolved: "opt/xclbin/kernel.xclbin",
+		},
+		{
+			// absolute link targets are inside the rootfs
+			path:     "/xclbin/kernel.xclbin",
+			resolved: "opt/xclbin/kernel.xclbin",
+		},
+		{
+			path:     "/opt/kernel.xclbin",
+			resolved: "opt/xclbin/kernel.xclbin",
+		},
+		{
+			path:       "../../../../etc/shadow",
+			shouldFail: true,
+		},
+		{
+			// /etc/shadow of the rootfs, which does not exist
+			path:       "/shadow",
+			shouldFail: true,
+		},
+		{
+			path:       "/opt/shadow",
+			shouldFail: true,
+		},
+		{
+			path:       "/loop",
+			shouldFail: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		resolved, err := resolveRootfsPath(rootfs, tc.path)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, path.Join(rootfs, tc.resolved), resolved, "%d: %v", i, tc)
+	}
+
+	_, err := resolveRootfsPath("", "/opt/xclbin/kernel.xclbin")
+	require.Error(t, err)
+}
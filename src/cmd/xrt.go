@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	envXLNXXrtMount        = "XILINX_XRT_MOUNT"
+	annotationXLNXXrtMount = "com.xilinx.xrt.mount"
+	xrtMountHost           = "host"
+	XrtVersionFile         = "version.json"
+	envXRT                 = "XILINX_XRT"
+	envLDLibraryPath       = "LD_LIBRARY_PATH"
+	envPath                = "PATH"
+	defaultPath            = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	ImageXrtPath           = "/opt/xilinx/xrt"
+	SysfsModules           = "/sys/module"
+	ModuleVersionFile      = "version"
+	xrtCheckWarn           = "warn"
+	xrtCheckDeny           = "deny"
+	xrtCheckIgnore         = "ignore"
+)
+
+// Kernel modules of XRT driver
+var xrtDriverModules = []string{"xocl", "xclmgmt"}
+
+// Version information of XRT installation, as written in version.json
+type xrtVersion struct {
+	BuildVersion string `json:"BUILD_VERSION"`
+	BuildBranch  string `json:"BUILD_BRANCH"`
+	VersionHash  string `json:"VERSION_HASH"`
+}
+
+// Return the XRT build version of an installation directory
+func getXrtVersion(xrtDir string) (string, error) {
+	buf, err := os.ReadFile(path.Join(xrtDir, XrtVersionFile))
+	if err != nil {
+		return "", fmt.Errorf("Can't read XRT version file in %s", xrtDir)
+	}
+
+	version := xrtVersion{}
+	err = json.Unmarshal(buf, &version)
+	if err != nil {
+		return "", fmt.Errorf("error parsing XRT version file in %s: %v", xrtDir, err)
+	}
+	return version.BuildVersion, nil
+}
+
+// prepend a directory to a colon separated list in environment variable of OCI Spec
+func prependSpecEnvPath(spec *specs.Spec, key string, dir string, fallback string) {
+	current := getSpecEnv(spec, key)
+	if current == "" {
+		current = fallback
+	}
+	for _, part := range strings.Split(current, ":") {
+		if part == dir {
+			return
+		}
+	}
+	if current == "" {
+		setSpecEnv(spec, key, dir)
+	} else {
+		setSpecEnv(spec, key, dir+":"+current)
+	}
+}
+
+// check if host XRT is requested by environment variable or annotation
+func (r xilinxContainerRuntime) hostXrtRequired(spec *specs.Spec) bool {
+	mode := getSpecEnv(spec, envXLNXXrtMount)
+	if mode == "" && spec.Annotations != nil {
+		mode = spec.Annotations[annotationXLNXXrtMount]
+	}
+	return strings.EqualFold(mode, xrtMountHost)
+}
+
+// bind-mount host XRT installation into the container and set up its environment
+func (r xilinxContainerRuntime) addHostXrt(spec *specs.Spec) error {
+	if !r.hostXrtRequired(spec) {
+		return nil
+	}
+
+	// Host XRT is looked up under the host root, but mounted by its path on host
+	xrtDir := r.cfg.xrtHostPath
+	hostVersion, err := getXrtVersion(hostPath(xrtDir))
+	if err != nil {
+		return fmt.Errorf("error getting host XRT version: %v", err)
+	}
+	r.logger.Infof("Host XRT version %s found in %s", hostVersion, xrtDir)
+
+	// Refuse to shadow an XRT installation shipped by the image, unless it is of the same version
+	if rootfs := r.getRootfsPath(spec); rootfs != "" {
+		imageXrtDir := path.Join(rootfs, xrtDir)
+		if fileExist(imageXrtDir) {
+			imageVersion, err := getXrtVersion(imageXrtDir)
+			if err != nil {
+				return fmt.Errorf("image ships XRT in %s without version information, which can't be checked against host XRT version %s: %v",
+					xrtDir, hostVersion, err)
+			} else if imageVersion != hostVersion {
+				return fmt.Errorf("image ships XRT version %s, which conflicts with host XRT version %s",
+					imageVersion, hostVersion)
+			}
+		}
+	}
+
+	sources := []string{xrtDir}
+	if fileExist(hostPath(r.cfg.xrtICDPath)) {
+		sources = append(sources, r.cfg.xrtICDPath)
+	} else {
+		r.logger.Warnf("OpenCL ICD file %s not found on host", r.cfg.xrtICDPath)
+	}
+	for _, source := range sources {
+		if mountExisted(spec, source) {
+			continue
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: source,
+			Type:        "none",
+			Source:      source,
+			Options:     []string{"ro", "nosuid", "nodev", "rbind"},
+		})
+	}
+
+	setSpecEnv(spec, envXRT, xrtDir)
+	prependSpecEnvPath(spec, envLDLibraryPath, path.Join(xrtDir, "lib"), "")
+	prependSpecEnvPath(spec, envPath, path.Join(xrtDir, "bin"), defaultPath)
+
+	r.logger.Infof("Host XRT %s mounted into the container", xrtDir)
+	return nil
+}
+
+// Return the versions of loaded XRT driver modules on host
+func getXrtDriverVersions() (map[string]string, error) {
+	versions := make(map[string]string)
+	for _, module := range xrtDriverModules {
+		fname := hostPath(path.Join(SysfsModules, module, ModuleVersionFile))
+		if !fileExist(fname) {
+			continue
+		}
+		content, err := getFileContent(fname)
+		if err != nil {
+			return nil, err
+		}
+		versions[module] = content
+	}
+	return versions, nil
+}
+
+// Return the major.minor release of an XRT version, like '2.13' for '2.13.466'
+func getXrtRelease(version string) string {
+	parts := strings.SplitN(strings.TrimSpace(version), ".", 3)
+	if len(parts) < 2 {
+		return strings.TrimSpace(version)
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// check XRT version compatibility between container and host driver while creating the container
+func (r xilinxContainerRuntime) checkXrtCompatibility(spec *specs.Spec) error {
+	policy := strings.ToLower(r.cfg.xrtVersionCheck)
+	if policy == xrtCheckIgnore {
+		return nil
+	}
+
+	visibleXilinxDevices, err := r.getVisibleDevices(spec)
+	if err != nil {
+		return err
+	} else if len(visibleXilinxDevices) == 0 {
+		return nil
+	}
+
+	// The policy only matters for containers with devices, which are refused if it is unknown
+	if policy != xrtCheckWarn && policy != xrtCheckDeny {
+		return fmt.Errorf("unknown XRT version check policy '%s'", r.cfg.xrtVersionCheck)
+	}
+
+	// XRT in the container is either mounted from host or shipped by the image
+	var xrtDir string
+	if r.hostXrtRequired(spec) {
+		xrtDir = hostPath(r.cfg.xrtHostPath)
+	} else if rootfs := r.getRootfsPath(spec); rootfs != "" {
+		xrtDir = path.Join(rootfs, ImageXrtPath)
+	}
+	if xrtDir == "" || !fileExist(path.Join(xrtDir, XrtVersionFile)) {
+		r.logger.Infof("No XRT version information found in the container, skipping version check")
+		return nil
+	}
+	containerVersion, err := getXrtVersion(xrtDir)
+	if err != nil {
+		return err
+	}
+
+	driverVersions, err := getXrtDriverVersions()
+	if err != nil {
+		return fmt.Errorf("error getting XRT driver version: %v", err)
+	}
+
+	for _, module := range xrtDriverModules {
+		driverVersion, loaded := driverVersions[module]
+		if !loaded {
+			continue
+		}
+		if getXrtRelease(driverVersion) == getXrtRelease(containerVersion) {
+			r.logger.Infof("XRT version %s in the container is compatible with %s driver version %s",
+				containerVersion, module, driverVersion)
+			continue
+		}
+		msg := fmt.Sprintf("XRT version %s in the container does not match %s driver version %s on host",
+			containerVersion, module, driverVersion)
+		if policy == xrtCheckDeny {
+			return fmt.Errorf("%s", msg)
+		}
+		r.logger.Warnf("%s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func writeXrtVersion(t *testing.T, xrtDir string, version string) {
+	require.NoError(t, os.MkdirAll(xrtDir, 0755))
+	content := `{"BUILD_VERSION": "` + version + `", "BUILD_BRANCH": "2022.1"}`
+	require.NoError(t, os.WriteFile(path.Join(xrtDir, XrtVersionFile), []byte(content), 0644))
+}
+
+func TestAddHostXrt(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	hostDir := t.TempDir()
+	xrtDir := path.Join(hostDir, "opt/xilinx/xrt")
+	writeXrtVersion(t, xrtDir, "2.13.466")
+
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			xrtHostPath: xrtDir,
+			xrtICDPath:  path.Join(hostDir, "xilinx.icd"),
+		},
+		bundleDir: t.TempDir(),
+	}
+
+	testCases := []struct {
+		env          []string
+		imageVersion string
+		shouldAdd    bool
+		shouldFail   bool
+	}{
+		{
+			env:       []string{},
+			shouldAdd: false,
+		},
+		{
+			env:       []string{"XILINX_XRT_MOUNT=host", "PATH=/usr/bin"},
+			shouldAdd: true,
+		},
+		{
+			env:          []string{"XILINX_XRT_MOUNT=host"},
+			imageVersion: "2.13.466",
+			shouldAdd:    true,
+		},
+		{
+			env:          []string{"XILINX_XRT_MOUNT=host"},
+			imageVersion: "2.12.427",
+			shouldFail:   true,
+		},
+		{
+			// XRT in the image without version.json is not shadowed
+			env:          []string{"XILINX_XRT_MOUNT=host"},
+			imageVersion: "none",
+			shouldFail:   true,
+		},
+	}
+
+	for i, tc := range testCases {
+		rootfs := path.Join(t.TempDir(), "rootfs")
+		if tc.imageVersion == "none" {
+			require.NoError(t, os.MkdirAll(path.Join(rootfs, xrtDir), 0755))
+		} else if tc.imageVersion != "" {
+			writeXrtVersion(t, path.Join(rootfs, xrtDir), tc.imageVersion)
+		}
+		spec := &specs.Spec{
+			Root:    &specs.Root{Path: rootfs},
+			Process: &specs.Process{Env: tc.env},
+		}
+
+		err := shim.addHostXrt(spec)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+			continue
+		}
+		require.NoErrorf(t, err, "%d: %v", i, tc)
+		require.Equalf(t, tc.shouldAdd, mountExisted(spec, xrtDir), "%d: %v", i, tc)
+		if tc.shouldAdd {
+			require.Equal(t, xrtDir, getSpecEnv(spec, envXRT))
+			require.Equal(t, path.Join(xrtDir, "lib"), getSpecEnv(spec, envLDLibraryPath))
+			require.Contains(t, getSpecEnv(spec, envPath), path.Join(xrtDir, "bin")+":")
+
+			// Modifying the spec again should not duplicate paths
+			require.NoError(t, shim.addHostXrt(spec))
+			require.Equal(t, path.Join(xrtDir, "lib"), getSpecEnv(spec, envLDLibraryPath))
+		}
+	}
+}
+
+func TestAddHostXrtUnderHostRoot(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	writeXrtVersion(t, path.Join(hostRoot, "/opt/xilinx/xrt"), "2.13.466")
+	writeSysfsFixture(t, hostRoot, map[string]string{"etc/OpenCL/vendors/xilinx.icd": "libxilinxopencl.so"})
+
+	logger, _ := testlog.NewNullLogger()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			xrtHostPath: "/opt/xilinx/xrt",
+			xrtICDPath:  "/etc/OpenCL/vendors/xilinx.icd",
+		},
+		bundleDir: t.TempDir(),
+	}
+	spec := &specs.Spec{
+		Root:    &specs.Root{Path: path.Join(t.TempDir(), "rootfs")},
+		Process: &specs.Process{Env: []string{"XILINX_XRT_MOUNT=host"}},
+	}
+
+	// Host XRT is found under the host root, and mounted by its path on host
+	require.NoError(t, shim.addHostXrt(spec))
+	require.True(t, mountExisted(spec, "/opt/xilinx/xrt"))
+	require.True(t, mountExisted(spec, "/etc/OpenCL/vendors/xilinx.icd"))
+	for _, mount := range spec.Mounts {
+		require.Equal(t, mount.Destination, mount.Source)
+	}
+}
+
+func TestGetXrtRelease(t *testing.T) {
+	testCases := map[string]string{
+		"2.13.466":   "2.13",
+		"2.13.466\n": "2.13",
+		"2.12":       "2.12",
+		"unknown":    "unknown",
+	}
+
+	for version, release := range testCases {
+		require.Equal(t, release, getXrtRelease(version), version)
+	}
+}
+
+func TestCheckXrtCompatibility(t *testing.T) {
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{"sys/module/xocl/version": "2.13.466"})
+	defer func(inv *deviceInventory) { inventory = inv }(inventory)
+	inventory = &deviceInventory{backends: []discoveryBackend{fakeBackend{
+		devices: []xilinxDevice{{DBDF: "0000:3b:00.1", Pair: &xilinxPair{}, state: deviceStateReady}},
+	}}}
+
+	testCases := []struct {
+		policy       string
+		devices      string
+		imageVersion string
+		shouldFail   bool
+	}{
+		{
+			policy:       xrtCheckDeny,
+			devices:      "0000:3b:00.1",
+			imageVersion: "2.13.479",
+		},
+		{
+			policy:       xrtCheckWarn,
+			devices:      "0000:3b:00.1",
+			imageVersion: "2.12.427",
+		},
+		{
+			policy:       xrtCheckDeny,
+			devices:      "0000:3b:00.1",
+			imageVersion: "2.12.427",
+			shouldFail:   true,
+		},
+		{
+			policy:       xrtCheckIgnore,
+			devices:      "0000:3b:00.1",
+			imageVersion: "2.12.427",
+		},
+		{
+			// images without XRT version information are not checked
+			policy:  xrtCheckDeny,
+			devices: "0000:3b:00.1",
+		},
+		{
+			policy:       "unknown",
+			devices:      "0000:3b:00.1",
+			imageVersion: "2.13.466",
+			shouldFail:   true,
+		},
+		{
+			// containers without devices are not affected by the policy
+			policy:       "unknown",
+			imageVersion: "2.12.427",
+		},
+	}
+
+	for i, tc := range testCases {
+		logger, _ := testlog.NewNullLogger()
+		shim := xilinxContainerRuntime{
+			logger: logger,
+			cfg:    &config{xrtVersionCheck: tc.policy},
+		}
+		rootfs := t.TempDir()
+		if tc.imageVersion != "" {
+			writeXrtVersion(t, path.Join(rootfs, ImageXrtPath), tc.imageVersion)
+		}
+		spec := &specs.Spec{
+			Root:    &specs.Root{Path: rootfs},
+			Process: &specs.Process{Env: []string{envXLNXVisibleDevices + "=" + tc.devices}},
+		}
+
+		err := shim.checkXrtCompatibility(spec)
+		if tc.shouldFail {
+			require.Errorf(t, err, "%d: %v", i, tc)
+		} else {
+			require.NoErrorf(t, err, "%d: %v", i, tc)
+		}
+	}
+}
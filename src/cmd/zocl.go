@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	SysfsZoclDriver      = "/sys/bus/platform/drivers/zocl"
+	SysfsPlatformDevices = "/sys/bus/platform/devices"
+	DeviceTreeModel      = "/sys/firmware/devicetree/base/model"
+)
+
+// check if the device is a platform device instead of a PCIe function
+func isPlatformDevice(device xilinxDevice) bool {
+	return device.driver == zoclDriver
+}
+
+// Return the sysfs folder of a device, either a PCIe function or a platform device
+func getDeviceSysfsDir(device xilinxDevice) string {
+	if isPlatformDevice(device) {
+		return path.Join(SysfsPlatformDevices, device.DBDF)
+	}
+	return path.Join(SysfsDevices, device.DBDF)
+}
+
+// Return the board model from the device tree, like 'ZynqMP SMK-K26 Rev1/B/A'
+func getDeviceTreeModel() string {
+	content, err := getFileContent(hostPath(DeviceTreeModel))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(content, "\x00")
+}
+
+/*
+Backend of accelerators on embedded platforms like Kria and Versal boards,
+which are platform devices bound to zocl instead of PCIe functions. The
+platform device name, like 'axi:zyxclmm_drm', takes the place of the DBDF,
+and the board model takes the place of the shell version.
+*/
+type zoclBackend struct{}
+
+func (b zoclBackend) Name() string {
+	return zoclDriver
+}
+
+// Return a list of Xilinx devices driven by zocl on host.
+func (b zoclBackend) Discover() ([]xilinxDevice, error) {
+	if !fileExist(hostPath(SysfsZoclDriver)) {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(hostPath(SysfsZoclDriver))
+	if err != nil {
+		return nil, fmt.Errorf("Can't read folder %s", SysfsZoclDriver)
+	}
+
+	devices := []xilinxDevice{}
+	model := getDeviceTreeModel()
+	for _, file := range files {
+		name := file.Name()
+		// Bound devices are links with drm folder, other files are driver attributes like bind and unbind
+		drmDir := hostPath(path.Join(SysfsZoclDriver, name, UserPFKeyword))
+		if !fileExist(drmDir) {
+			continue
+		}
+		userpf, err := getFileNameFromPrefix(drmDir, DRMSTR)
+		if err != nil {
+			return nil, err
+		}
+		if userpf == "" {
+			continue
+		}
+
+		devices = append(devices, xilinxDevice{
+			shellVer: model,
+			DBDF:     name,
+			Pair: &xilinxPair{
+				User: path.Join(UserPrefix, userpf),
+			},
+		})
+	}
+	return devices, nil
+}
+
+// mount firmware folders used to load accelerators on embedded platforms, like /lib/firmware/xilinx
+func (r xilinxContainerRuntime) addZoclMounts(spec *specs.Spec) {
+	for _, mount := range r.cfg.zoclMounts {
+		if mountExisted(spec, mount) || !fileExist(hostPath(mount)) {
+			continue
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: mount,
+			Type:        "none",
+			Source:      mount,
+			Options:     []string{"ro", "nosuid", "nodev", "rbind"},
+		})
+	}
+}
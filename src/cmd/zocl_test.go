@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2022, Xilinx Inc - All rights reserved
+ * Xilinx Container Runtime
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may
+ * not use this file except in compliance with the License. A copy of the
+ * License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"path"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	testlog "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDeviceSysfsDir(t *testing.T) {
+	testCases := []struct {
+		device   xilinxDevice
+		expected string
+	}{
+		{
+			device:   xilinxDevice{DBDF: "0000:3b:00.1"},
+			expected: "/sys/bus/pci/devices/0000:3b:00.1",
+		},
+		{
+			device:   xilinxDevice{DBDF: "axi:zyxclmm_drm", driver: zoclDriver},
+			expected: "/sys/bus/platform/devices/axi:zyxclmm_drm",
+		},
+	}
+
+	for i, tc := range testCases {
+		require.Equalf(t, tc.expected, getDeviceSysfsDir(tc.device), "%d: %v", i, tc)
+	}
+}
+
+func TestAddZoclMounts(t *testing.T) {
+	logger, _ := testlog.NewNullLogger()
+	firmware := t.TempDir()
+	shim := xilinxContainerRuntime{
+		logger: logger,
+		cfg: &config{
+			zoclMounts: []string{firmware, path.Join(firmware, "missing")},
+		},
+	}
+
+	spec := &specs.Spec{}
+	shim.addZoclMounts(spec)
+	shim.addZoclMounts(spec)
+	require.Equal(t, 1, len(spec.Mounts))
+	require.Equal(t, firmware, spec.Mounts[0].Destination)
+	require.Contains(t, spec.Mounts[0].Options, "ro")
+
+	// Folders are looked up under the host root, but mounted by their host paths
+	defer func(root string) { hostRoot = root }(hostRoot)
+	hostRoot = t.TempDir()
+	writeSysfsFixture(t, hostRoot, map[string]string{"lib/firmware/xilinx": ""})
+	shim.cfg.zoclMounts = []string{"/lib/firmware/xilinx", "/lib/firmware/missing"}
+	spec = &specs.Spec{}
+	shim.addZoclMounts(spec)
+	require.Equal(t, 1, len(spec.Mounts))
+	require.Equal(t, "/lib/firmware/xilinx", spec.Mounts[0].Source)
+	require.Equal(t, "/lib/firmware/xilinx", spec.Mounts[0].Destination)
+}